@@ -0,0 +1,90 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// KeyPlacement is the placement of the API key in the request
+type KeyPlacement int
+
+const (
+	// KeyInQuery sends the API key as a query parameter
+	KeyInQuery KeyPlacement = iota
+	// KeyInHeader sends the API key as a request header
+	KeyInHeader
+)
+
+// DefaultAPIKeyName is the name of the query parameter, or the header, used
+// for the API key when APIKey.Name is not provided
+const DefaultAPIKeyName = "apikey"
+
+// APIKey is the credential for the authenticated API tiers. The Key is never
+// logged or returned in any error, only its Redacted form is.
+type APIKey struct {
+	Key  string
+	In   KeyPlacement
+	Name string
+}
+
+// Redacted returns the redacted form of the Key, which only reveals its first
+// few characters, if it is long enough
+func (k *APIKey) Redacted() string {
+	if k == nil || k.Key == "" {
+		return ""
+	}
+	if len(k.Key) < 12 {
+		return "****"
+	}
+	return k.Key[:4] + "****"
+}
+
+// String returns the Redacted form, so that the APIKey is safe to be logged
+func (k *APIKey) String() string {
+	return k.Redacted()
+}
+
+// apply attaches the API key to the request
+func (k *APIKey) apply(req *http.Request) {
+	if k == nil || k.Key == "" {
+		return
+	}
+	name := k.Name
+	if name == "" {
+		name = DefaultAPIKeyName
+	}
+	if k.In == KeyInHeader {
+		req.Header.Set(name, k.Key)
+		return
+	}
+	q := req.URL.Query()
+	q.Set(name, k.Key)
+	req.URL.RawQuery = q.Encode()
+}
+
+// redact replaces every occurrence of the API key in s with its Redacted form
+func (k *APIKey) redact(s string) string {
+	if k == nil || k.Key == "" {
+		return s
+	}
+	s = strings.Replace(s, k.Key, k.Redacted(), -1)
+	if escaped := url.QueryEscape(k.Key); escaped != k.Key {
+		s = strings.Replace(s, escaped, k.Redacted(), -1)
+	}
+	return s
+}
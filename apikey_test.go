@@ -0,0 +1,100 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP_test
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Shivam010/go-freeGeoIP"
+)
+
+const testAPIKey = "s3cr3t-api-key-0123456789"
+
+func TestAPIKey(t *testing.T) {
+	tests := []struct {
+		name string
+		key  *freeGeoIP.APIKey
+		got  func(r *http.Request) string
+	}{
+		{
+			name: "In Query",
+			key:  &freeGeoIP.APIKey{Key: testAPIKey},
+			got:  func(r *http.Request) string { return r.URL.Query().Get(freeGeoIP.DefaultAPIKeyName) },
+		},
+		{
+			name: "In Header",
+			key:  &freeGeoIP.APIKey{Key: testAPIKey, In: freeGeoIP.KeyInHeader, Name: "X-API-Key"},
+			got:  func(r *http.Request) string { return r.Header.Get("X-API-Key") },
+		},
+		{
+			name: "Custom Query Parameter",
+			key:  &freeGeoIP.APIKey{Key: testAPIKey, Name: "key"},
+			got:  func(r *http.Request) string { return r.URL.Query().Get("key") },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.got(r) != testAPIKey {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				_, _ = w.Write([]byte(`{"ip":"` + dnsIP + `","time_zone":""}`))
+			}))
+			defer srv.Close()
+
+			cli := &freeGeoIP.Client{BaseURL: srv.URL, APIKey: tt.key}
+			if res := cli.GetGeoInfoFromString(context.Background(), dnsIP); res.Error != nil {
+				t.Fatalf("GetGeoInfoFromString() error = %v, want no error", res.Error)
+			}
+
+			cli.APIKey = &freeGeoIP.APIKey{Key: "invalid", In: tt.key.In, Name: tt.key.Name}
+			if res := cli.GetGeoInfoFromString(context.Background(), dnsIP); res.Error != freeGeoIP.ErrInvalidKey {
+				t.Fatalf("GetGeoInfoFromString() error = %v, want %v", res.Error, freeGeoIP.ErrInvalidKey)
+			}
+		})
+	}
+}
+
+func TestAPIKeyIsRedacted(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		conn.Close()
+	}))
+	defer srv.Close()
+
+	buf := &bytes.Buffer{}
+	key := &freeGeoIP.APIKey{Key: testAPIKey}
+	cli := &freeGeoIP.Client{BaseURL: srv.URL, APIKey: key, Logger: log.New(buf, "", 0)}
+	res := cli.GetGeoInfoFromString(context.Background(), dnsIP)
+	if res.Error == nil {
+		t.Fatalf("GetGeoInfoFromString() error = nil, want error")
+	}
+	if strings.Contains(res.Error.Error(), testAPIKey) {
+		t.Fatalf("GetGeoInfoFromString() error %q must not contain the api key", res.Error)
+	}
+	if !strings.Contains(buf.String(), key.Redacted()) {
+		t.Fatalf("logs %q must contain the redacted api key %q", buf.String(), key.Redacted())
+	}
+	if strings.Contains(buf.String(), testAPIKey) {
+		t.Fatalf("logs %q must not contain the api key", buf.String())
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBatchConcurrency is the default number of concurrent API calls made
+// by GetGeoInfoBatch
+const DefaultBatchConcurrency = 8
+
+// BatchOptions are the optional parameters for the GetGeoInfoBatch
+type BatchOptions struct {
+	// Concurrency is the maximum number of concurrent API calls, if not
+	// positive DefaultBatchConcurrency will be used
+	Concurrency int
+	// StopOnLimit stops making any new API call once `ErrLimitReached` is
+	// returned, the remaining ips will be responded with `ErrLimitReached`
+	StopOnLimit bool
+}
+
+// GetGeoInfoBatch will return the free geolocation api responses for all the
+// provided ips, in the same order as the ips. Duplicate ips are looked up
+// only once, cached ones are served without any API call and the rest are
+// looked up concurrently, bounded by the BatchOptions.Concurrency.
+// The opts can be nil, for the default options.
+func (c *Client) GetGeoInfoBatch(ctx context.Context, ips []IP, opts *BatchOptions) []Response {
+	c.init()
+	if opts == nil {
+		opts = &BatchOptions{}
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+
+	// dedup the ips, positions maintains the indexes of every unique ip
+	var unique []IP
+	positions := map[string][]int{}
+	for i, ip := range ips {
+		key := ip.String()
+		if _, ok := positions[key]; !ok {
+			unique = append(unique, ip)
+		}
+		positions[key] = append(positions[key], i)
+	}
+
+	// check cache
+	results := make([]Response, len(unique))
+	var misses []int
+	for i, info := range c.getBatch(ctx, unique) {
+		if info != nil && precise(ctx, info) {
+			c.log("cache is hit for '" + unique[i].String())
+			results[i] = c.fillResponse(info, nil, nil, struct{}{})
+			continue
+		}
+		misses = append(misses, i)
+	}
+	c.log("batch of", len(unique), "ips has", len(misses), "cache misses")
+
+	// call api for the misses
+	var (
+		wg      sync.WaitGroup
+		limited int32
+		sem     = make(chan struct{}, concurrency)
+	)
+	for _, i := range misses {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if err := ctx.Err(); err != nil {
+			results[i] = c.fillResponse(nil, wrapError("http", err), nil)
+			continue
+		}
+		if opts.StopOnLimit && atomic.LoadInt32(&limited) == 1 {
+			<-sem
+			results[i] = c.fillResponse(nil, ErrLimitReached, nil)
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			res := c.fetch(ctx, unique[i].String())
+			if res.Error == ErrLimitReached {
+				atomic.StoreInt32(&limited, 1)
+			}
+			results[i] = res
+		}(i)
+	}
+	wg.Wait()
+
+	// every position gets its own copy of the response
+	responses := make([]Response, len(ips))
+	for i, ip := range unique {
+		for _, pos := range positions[ip.String()] {
+			res := results[i]
+			res.Info = copyInfo(res.Info)
+			responses[pos] = res
+		}
+	}
+	return responses
+}
+
+// getBatch returns the cached information of the ips, nil for the missed ones,
+// with a single call if the Cache implements IBatchCache
+func (c *Client) getBatch(ctx context.Context, ips []IP) []*Info {
+	infos := make([]*Info, len(ips))
+	if bc, ok := c.Cache.(IBatchCache); ok {
+		cached, err := bc.GetBatch(ctx, ips)
+		if err != nil {
+			c.log("batch cache error:", err)
+			return infos
+		}
+		copy(infos, cached)
+		return infos
+	}
+	for i, ip := range ips {
+		if info, err := c.Cache.Get(ctx, ip); err == nil {
+			infos[i] = info
+		}
+	}
+	return infos
+}
@@ -0,0 +1,172 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP"
+)
+
+func TestGetGeoInfoBatch(t *testing.T) {
+	var calls, running, maxRunning int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		cur := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if cur <= max || atomic.CompareAndSwapInt32(&maxRunning, max, cur) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		ip := strings.TrimPrefix(r.URL.Path, "/json/")
+		_, _ = w.Write([]byte(`{"ip":"` + ip + `","time_zone":""}`))
+	}))
+	defer srv.Close()
+
+	cli := &freeGeoIP.Client{Cache: freeGeoIP.DefaultCache(), BaseURL: srv.URL}
+	ctx := context.Background()
+	if res := cli.GetGeoInfoFromString(ctx, dnsIP); res.Error != nil {
+		t.Fatalf("GetGeoInfoFromString() error = %v, want no error", res.Error)
+	}
+	atomic.StoreInt32(&calls, 0)
+
+	ips := []freeGeoIP.IP{
+		freeGeoIP.ParseIP("1.1.1.1"),
+		freeGeoIP.ParseIP(dnsIP),
+		freeGeoIP.ParseIP("1.1.1.1"),
+		freeGeoIP.ParseIP("1.0.0.1"),
+		freeGeoIP.ParseIP("9.9.9.9"),
+		freeGeoIP.ParseIP(responseIP),
+	}
+	got := cli.GetGeoInfoBatch(ctx, ips, &freeGeoIP.BatchOptions{Concurrency: 2})
+	if len(got) != len(ips) {
+		t.Fatalf("GetGeoInfoBatch() responses got = %v, want %v", len(got), len(ips))
+	}
+	for i, res := range got {
+		if res.Error != nil {
+			t.Fatalf("GetGeoInfoBatch()[%v] error = %v, want no error", i, res.Error)
+		}
+		if res.Info.IP.String() != ips[i].String() {
+			t.Fatalf("GetGeoInfoBatch()[%v] ip got = %v, want %v", i, res.Info.IP, ips[i])
+		}
+	}
+	if !got[1].Cached {
+		t.Fatalf("GetGeoInfoBatch() for cached ip output must be cached")
+	}
+	if got[0].Info == got[2].Info {
+		t.Fatalf("GetGeoInfoBatch() duplicate ips must not share the Info")
+	}
+	if calls != 4 {
+		t.Fatalf("API calls got = %v, want %v", calls, 4)
+	}
+	if maxRunning > 2 {
+		t.Fatalf("concurrent API calls got = %v, want at most %v", maxRunning, 2)
+	}
+}
+
+func TestGetGeoInfoBatch_StopOnLimit(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	cli := &freeGeoIP.Client{BaseURL: srv.URL}
+	ips := []freeGeoIP.IP{
+		freeGeoIP.ParseIP("1.1.1.1"),
+		freeGeoIP.ParseIP("1.0.0.1"),
+		freeGeoIP.ParseIP("9.9.9.9"),
+	}
+	got := cli.GetGeoInfoBatch(context.Background(), ips, &freeGeoIP.BatchOptions{
+		Concurrency: 1,
+		StopOnLimit: true,
+	})
+	for i, res := range got {
+		if res.Error != freeGeoIP.ErrLimitReached {
+			t.Fatalf("GetGeoInfoBatch()[%v] error = %v, want %v", i, res.Error, freeGeoIP.ErrLimitReached)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("API calls got = %v, want %v", calls, 1)
+	}
+}
+
+// batchCache is the IBatchCache which counts its Get and GetBatch calls
+type batchCache struct {
+	freeGeoIP.ICache
+	gets, batches int32
+	err           error
+}
+
+func (b *batchCache) Get(ctx context.Context, ip freeGeoIP.IP) (*freeGeoIP.Info, error) {
+	atomic.AddInt32(&b.gets, 1)
+	return b.ICache.Get(ctx, ip)
+}
+
+func (b *batchCache) GetBatch(ctx context.Context, ips []freeGeoIP.IP) ([]*freeGeoIP.Info, error) {
+	atomic.AddInt32(&b.batches, 1)
+	if b.err != nil {
+		return nil, b.err
+	}
+	infos := make([]*freeGeoIP.Info, len(ips))
+	for i, ip := range ips {
+		infos[i], _ = b.ICache.Get(ctx, ip)
+	}
+	return infos, nil
+}
+
+func TestGetGeoInfoBatch_BatchCache(t *testing.T) {
+	srv := fakeAPI()
+	defer srv.Close()
+	cache := &batchCache{ICache: freeGeoIP.DefaultCache()}
+	cli := fakeClient(srv)
+	cli.Cache = cache
+	ctx := context.Background()
+	cache.Set(ctx, response())
+
+	ips := []freeGeoIP.IP{freeGeoIP.ParseIP(responseIP), freeGeoIP.ParseIP(broadcastIP)}
+	got := cli.GetGeoInfoBatch(ctx, ips, nil)
+	if got[0].Error != nil || !got[0].Cached || compare(t, got[0].Info, response()) {
+		t.Fatalf("GetGeoInfoBatch()[0] got = %+v", got[0])
+	}
+	if got[1].Error != nil || got[1].Cached {
+		t.Fatalf("GetGeoInfoBatch()[1] got = %+v", got[1])
+	}
+	if cache.batches != 1 || cache.gets != 0 {
+		t.Fatalf("GetBatch(), Get() calls got = %v, %v, want %v, %v", cache.batches, cache.gets, 1, 0)
+	}
+
+	// a failed batch misses every ip
+	cache.err = freeGeoIP.ErrInternal
+	got = cli.GetGeoInfoBatch(ctx, ips, nil)
+	for i, res := range got {
+		if res.Error != nil || res.Cached {
+			t.Fatalf("GetGeoInfoBatch()[%v] got = %+v", i, res)
+		}
+	}
+	if srv.Requests() != 3 {
+		t.Fatalf("API calls got = %v, want %v", srv.Requests(), 3)
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP
+
+import (
+	"container/heap"
+	"container/list"
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP/internal/cacheutil"
+)
+
+// EvictionPolicy is the policy of the BoundedCache to choose the entry to
+// evict, once it is full
+type EvictionPolicy int
+
+const (
+	// EvictLRU evicts the least recently used entry
+	EvictLRU EvictionPolicy = iota
+	// EvictLFU evicts the least frequently used entry, and the least recently
+	// used among them
+	EvictLFU
+)
+
+// _EntryOverhead is the approximate memory used by a cache entry, apart from
+// its strings
+const _EntryOverhead = 256
+
+// BoundedCacheConfig is the configuration of the BoundedCache.
+// If MaxEntries and MaxBytes are both not provided, the cache is unbounded,
+// the MaxBytes is an approximation of the memory used by the entries
+// Expiry and ExpiryFn are same as the expiry and the expFn of the NewCache
+type BoundedCacheConfig struct {
+	MaxEntries int
+	MaxBytes   int64
+	Policy     EvictionPolicy
+	Expiry     time.Duration
+	ExpiryFn   CacheExpiryFunction
+}
+
+// BoundedCache is the in-memory ICache, and IHostCache, with a bounded number
+// of entries or size, which evicts the entries as per its EvictionPolicy. It
+// is safe for the concurrent use.
+type BoundedCache struct {
+	cfg    BoundedCacheConfig
+	expiry cacheutil.Expiry
+
+	mu      sync.Mutex
+	entries map[string]*boundedEntry
+	lru     *list.List
+	lfu     lfuHeap
+	bytes   int64
+	tick    uint64
+}
+
+// boundedEntry is an entry of the BoundedCache
+type boundedEntry struct {
+	key     string
+	info    *Info
+	expires time.Time
+	size    int64
+
+	// elem is the element in the lru list, for EvictLRU
+	elem *list.Element
+	// freq, tick and index are the use frequency, the last use and the
+	// position in the lfu heap, for EvictLFU
+	freq  uint64
+	tick  uint64
+	index int
+}
+
+// NewBoundedCache returns the BoundedCache with the configuration
+func NewBoundedCache(cfg BoundedCacheConfig) *BoundedCache {
+	expiry := cfg.Expiry
+	if expiry < 0 && expiry != SkipCache {
+		expiry = NoCacheExpiration
+	}
+	return &BoundedCache{
+		cfg:     cfg,
+		expiry:  expiryOf(expiry, cfg.ExpiryFn),
+		entries: map[string]*boundedEntry{},
+		lru:     list.New(),
+	}
+}
+
+// Len returns the number of the entries in the cache, including the expired
+// ones not evicted yet
+func (c *BoundedCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Bytes returns the approximate memory used by the entries in the cache
+func (c *BoundedCache) Bytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytes
+}
+
+// Set saves the info in cache, as per its expiry, evicting the entries if the
+// cache is full
+func (c *BoundedCache) Set(ctx context.Context, info *Info) {
+	if info == nil {
+		return
+	}
+	c.set(ctx, info.IP.String(), info)
+}
+
+// Get retrieves the cached ip info, if not found or expired then a cache
+// missed error, `ErrCacheMissed` will be returned
+// The error will also be returned when explicit cache miss is requested
+func (c *BoundedCache) Get(ctx context.Context, ip IP) (*Info, error) {
+	if c.expiry.Skip(ctx, net.IP(ip)) {
+		return nil, ErrCacheMissed
+	}
+	return c.get(ip.String())
+}
+
+// SetHost saves the info in cache under the hostname, as per the expiry of
+// the info's ip
+func (c *BoundedCache) SetHost(ctx context.Context, host string, info *Info) {
+	if info == nil {
+		return
+	}
+	c.set(ctx, cacheutil.HostKey(host), info)
+}
+
+// GetHost retrieves the cached hostname info, if not found or expired then a
+// cache missed error, `ErrCacheMissed` will be returned
+// If the ip of the hostname is denied by the ExpiryFn, the hit is the miss,
+// but the entry is still counted as used by the eviction Policy
+func (c *BoundedCache) GetHost(ctx context.Context, host string) (*Info, error) {
+	info, err := c.get(cacheutil.HostKey(host))
+	if err != nil || c.expiry.Skip(ctx, net.IP(info.IP)) {
+		return nil, ErrCacheMissed
+	}
+	return info, nil
+}
+
+func (c *BoundedCache) set(ctx context.Context, key string, info *Info) {
+	now := time.Now()
+	expires, ok := c.expiry.At(ctx, net.IP(info.IP), now)
+	if !ok {
+		return
+	}
+	e := &boundedEntry{key: key, info: info, expires: expires, size: entrySize(key, info)}
+	if c.cfg.MaxBytes > 0 && e.size > c.cfg.MaxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old, ok := c.entries[key]; ok {
+		e.freq = old.freq
+		c.remove(old)
+	}
+	// the new entry is always admitted, so that it is not the one evicted
+	c.evict(e.size)
+	c.entries[key] = e
+	c.bytes += e.size
+	if c.cfg.Policy == EvictLFU {
+		c.touch(e)
+		heap.Push(&c.lfu, e)
+	} else {
+		e.elem = c.lru.PushFront(e)
+	}
+}
+
+func (c *BoundedCache) get(key string) (*Info, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, ErrCacheMissed
+	}
+	if !e.expires.IsZero() && !time.Now().Before(e.expires) {
+		c.remove(e)
+		return nil, ErrCacheMissed
+	}
+	if c.cfg.Policy == EvictLFU {
+		c.touch(e)
+		heap.Fix(&c.lfu, e.index)
+	} else {
+		c.lru.MoveToFront(e.elem)
+	}
+	return e.info, nil
+}
+
+// touch records the use of the entry, for EvictLFU
+func (c *BoundedCache) touch(e *boundedEntry) {
+	c.tick++
+	e.freq++
+	e.tick = c.tick
+}
+
+// evict evicts the entries until the cache has the room for an entry of the
+// size, the lock must be held
+func (c *BoundedCache) evict(size int64) {
+	for len(c.entries) > 0 && c.full(size) {
+		var victim *boundedEntry
+		if c.cfg.Policy == EvictLFU {
+			victim = c.lfu[0]
+		} else {
+			victim = c.lru.Back().Value.(*boundedEntry)
+		}
+		c.remove(victim)
+	}
+}
+
+// full reports whether the cache has no room for an entry of the size
+func (c *BoundedCache) full(size int64) bool {
+	return (c.cfg.MaxEntries > 0 && len(c.entries) >= c.cfg.MaxEntries) ||
+		(c.cfg.MaxBytes > 0 && c.bytes+size > c.cfg.MaxBytes)
+}
+
+// remove removes the entry from the cache, the lock must be held
+func (c *BoundedCache) remove(e *boundedEntry) {
+	delete(c.entries, e.key)
+	c.bytes -= e.size
+	if c.cfg.Policy == EvictLFU {
+		heap.Remove(&c.lfu, e.index)
+	} else {
+		c.lru.Remove(e.elem)
+	}
+}
+
+// entrySize returns the approximate memory used by the entry
+func entrySize(key string, info *Info) int64 {
+	return int64(_EntryOverhead + 2*len(key) + len(info.IP) + len(info.CountryCode) + len(info.CountryName) +
+		len(info.RegionCode) + len(info.RegionName) + len(info.City) + len(info.ZipCode))
+}
+
+// lfuHeap is the min heap of the entries by their use frequency, and their
+// last use
+type lfuHeap []*boundedEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].tick < h[j].tick
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	e := x.(*boundedEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,175 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP"
+)
+
+// ipInfo returns the Info of the ith test ip
+func ipInfo(i int) *freeGeoIP.Info {
+	return &freeGeoIP.Info{IP: freeGeoIP.ParseIP("10.0." + strconv.Itoa(i/256) + "." + strconv.Itoa(i%256))}
+}
+
+func TestBoundedCacheLRU(t *testing.T) {
+	cache := freeGeoIP.NewBoundedCache(freeGeoIP.BoundedCacheConfig{MaxEntries: 3})
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		cache.Set(ctx, ipInfo(i))
+	}
+	// 0 is used recently, so 1 is evicted
+	if _, err := cache.Get(ctx, ipInfo(0).IP); err != nil {
+		t.Fatalf("cache.Get() error = %v, want no error", err)
+	}
+	cache.Set(ctx, ipInfo(3))
+	if cache.Len() != 3 {
+		t.Fatalf("Len() got = %v, want %v", cache.Len(), 3)
+	}
+	for i, want := range []error{nil, freeGeoIP.ErrCacheMissed, nil, nil} {
+		if _, err := cache.Get(ctx, ipInfo(i).IP); err != want {
+			t.Fatalf("cache.Get(%v) error = %v, want %v", i, err, want)
+		}
+	}
+}
+
+func TestBoundedCacheLFU(t *testing.T) {
+	cache := freeGeoIP.NewBoundedCache(freeGeoIP.BoundedCacheConfig{MaxEntries: 3, Policy: freeGeoIP.EvictLFU})
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		cache.Set(ctx, ipInfo(i))
+	}
+	// 0 and 2 are used more, so 1 is evicted, then 3 is the least used
+	for _, i := range []int{0, 0, 2, 2, 1} {
+		if _, err := cache.Get(ctx, ipInfo(i).IP); err != nil {
+			t.Fatalf("cache.Get(%v) error = %v, want no error", i, err)
+		}
+	}
+	cache.Set(ctx, ipInfo(3))
+	cache.Set(ctx, ipInfo(4))
+	for i, want := range []error{nil, freeGeoIP.ErrCacheMissed, nil, freeGeoIP.ErrCacheMissed, nil} {
+		if _, err := cache.Get(ctx, ipInfo(i).IP); err != want {
+			t.Fatalf("cache.Get(%v) error = %v, want %v", i, err, want)
+		}
+	}
+}
+
+func TestBoundedCacheBytes(t *testing.T) {
+	cache := freeGeoIP.NewBoundedCache(freeGeoIP.BoundedCacheConfig{MaxBytes: 4096})
+	ctx := context.Background()
+	for i := 0; i < 1000; i++ {
+		cache.Set(ctx, ipInfo(i))
+		if cache.Bytes() > 4096 {
+			t.Fatalf("Bytes() got = %v, want at most %v", cache.Bytes(), 4096)
+		}
+	}
+	if n := cache.Len(); n == 0 || n >= 1000 {
+		t.Fatalf("Len() got = %v", n)
+	}
+	if _, err := cache.Get(ctx, ipInfo(999).IP); err != nil {
+		t.Fatalf("cache.Get() error = %v, want no error", err)
+	}
+}
+
+func TestBoundedCacheExpiry(t *testing.T) {
+	cache := freeGeoIP.NewBoundedCache(freeGeoIP.BoundedCacheConfig{
+		MaxEntries: 10,
+		Expiry:     10 * time.Millisecond,
+		ExpiryFn: func(ctx context.Context, ip freeGeoIP.IP) time.Duration {
+			switch ip.String() {
+			case broadcastIP:
+				return freeGeoIP.SkipCache
+			case dnsIP:
+				return freeGeoIP.NoCacheExpiration
+			}
+			return 0 // the Expiry
+		},
+	})
+	ctx := context.Background()
+	cache.Set(ctx, response())
+	cache.Set(ctx, broadcastResponse())
+	cache.Set(ctx, &freeGeoIP.Info{IP: freeGeoIP.ParseIP(dnsIP)})
+	cache.SetHost(ctx, "example.com", response())
+	if got, err := cache.Get(ctx, freeGeoIP.ParseIP(responseIP)); err != nil || compare(t, got, response()) {
+		t.Fatalf("cache.Get() error = %v, want no error", err)
+	}
+	if _, err := cache.GetHost(ctx, "example.com"); err != nil {
+		t.Fatalf("cache.GetHost() error = %v, want no error", err)
+	}
+	if _, err := cache.Get(ctx, freeGeoIP.ParseIP(broadcastIP)); err != freeGeoIP.ErrCacheMissed {
+		t.Fatalf("cache.Get() error = %v, want %v", err, freeGeoIP.ErrCacheMissed)
+	}
+
+	time.Sleep(15 * time.Millisecond)
+	if _, err := cache.Get(ctx, freeGeoIP.ParseIP(responseIP)); err != freeGeoIP.ErrCacheMissed {
+		t.Fatalf("cache.Get() error = %v, want %v", err, freeGeoIP.ErrCacheMissed)
+	}
+	if _, err := cache.GetHost(ctx, "example.com"); err != freeGeoIP.ErrCacheMissed {
+		t.Fatalf("cache.GetHost() error = %v, want %v", err, freeGeoIP.ErrCacheMissed)
+	}
+	if _, err := cache.Get(ctx, freeGeoIP.ParseIP(dnsIP)); err != nil {
+		t.Fatalf("cache.Get() error = %v, want no error", err)
+	}
+	if cache.Len() != 1 {
+		t.Fatalf("Len() got = %v, want %v", cache.Len(), 1)
+	}
+}
+
+func TestBoundedCacheConcurrent(t *testing.T) {
+	for _, policy := range []freeGeoIP.EvictionPolicy{freeGeoIP.EvictLRU, freeGeoIP.EvictLFU} {
+		cache := freeGeoIP.NewBoundedCache(freeGeoIP.BoundedCacheConfig{MaxEntries: 50, Policy: policy})
+		ctx := context.Background()
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 1000; i++ {
+					info := ipInfo((g*31 + i) % 200)
+					cache.Set(ctx, info)
+					if got, err := cache.Get(ctx, info.IP); err == nil && got.IP.String() != info.IP.String() {
+						t.Errorf("cache.Get() got = %v, want %v", got.IP, info.IP)
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+		if cache.Len() > 50 {
+			t.Fatalf("Len() got = %v, want at most %v", cache.Len(), 50)
+		}
+	}
+}
+
+func TestBoundedCacheClient(t *testing.T) {
+	provider := &tableProvider{table: map[string]*freeGeoIP.Info{responseIP: response()}}
+	cli := &freeGeoIP.Client{
+		Cache:    freeGeoIP.NewBoundedCache(freeGeoIP.BoundedCacheConfig{MaxEntries: 100, Expiry: time.Hour}),
+		Provider: provider,
+	}
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if res := cli.GetGeoInfoFromString(ctx, responseIP); res.Error != nil || res.Cached != (i > 0) {
+			t.Fatalf("GetGeoInfoFromString() got = %+v", res)
+		}
+	}
+	if provider.Calls() != 1 {
+		t.Fatalf("provider calls got = %v, want %v", provider.Calls(), 1)
+	}
+}
@@ -16,20 +16,39 @@ package freeGeoIP
 
 import (
 	"context"
+	"net"
 	"time"
 
+	"github.com/Shivam010/go-freeGeoIP/internal/cacheutil"
 	"github.com/patrickmn/go-cache"
 )
 
 // ICache is the custom cache interface used by the library.
 // If Info is not found in the cache in Get, then the system
 // default error should be returned i.e. `ErrCacheMissed`
-// See it's default implementation: _Cache
+// See it's default implementation: shardedCache
 type ICache interface {
 	Set(ctx context.Context, info *Info)
 	Get(ctx context.Context, ip IP) (*Info, error)
 }
 
+// IHostCache is an optional interface for the ICache implementations, to
+// cache the information looked up by hostnames. Like in ICache, if Info is
+// not found in GetHost then `ErrCacheMissed` should be returned.
+type IHostCache interface {
+	SetHost(ctx context.Context, host string, info *Info)
+	GetHost(ctx context.Context, host string) (*Info, error)
+}
+
+// IBatchCache is an optional interface for the ICache implementations, which
+// can get the information of many ips at once, like with a single round trip
+// to a remote cache. The returned Infos are in the same order as the ips, and
+// nil for the ones not found. The error is returned only if the whole batch
+// failed, then all the ips are considered missed.
+type IBatchCache interface {
+	GetBatch(ctx context.Context, ips []IP) ([]*Info, error)
+}
+
 // NoopCache empty cache implementation
 type NoopCache struct{}
 
@@ -41,6 +60,14 @@ func (n NoopCache) Get(context.Context, IP) (*Info, error) {
 	return nil, ErrCacheMissed
 }
 
+// SetHost does nothing
+func (n NoopCache) SetHost(context.Context, string, *Info) {}
+
+// GetHost does nothing
+func (n NoopCache) GetHost(context.Context, string) (*Info, error) {
+	return nil, ErrCacheMissed
+}
+
 // CacheExpiryFunction is the functional parameter for the Cache implementation
 // to change expiry for certain IP set or context conditions.
 type CacheExpiryFunction func(ctx context.Context, ip IP) time.Duration
@@ -61,14 +88,16 @@ type _Cache struct {
 	expFn CacheExpiryFunction
 }
 
-// DefaultCache is the default cache implementation with 24 Hours expiry
+// DefaultCache is the default cache implementation with 24 Hours expiry, see
+// NewShardedCache
 func DefaultCache() ICache {
-	return NewCache(DefaultCacheExpiry, nil)
+	return NewShardedCache(DefaultCacheExpiry, nil)
 }
 
-// NonExpiryCache is the default cache implementation without any expiry
+// NonExpiryCache is the default cache implementation without any expiry, see
+// NewShardedCache
 func NonExpiryCache() ICache {
-	return NewCache(NoCacheExpiration, nil)
+	return NewShardedCache(NoCacheExpiration, nil)
 }
 
 // NewCache is the constructor that returns the ICache implementation with
@@ -125,3 +154,42 @@ func (c *_Cache) Get(ctx context.Context, ip IP) (*Info, error) {
 	}
 	return nil, ErrCacheMissed
 }
+
+// SetHost will use the expiry duration of the info's ip and save info in
+// cache under the hostname
+func (c *_Cache) SetHost(ctx context.Context, host string, info *Info) {
+	if info == nil {
+		return
+	}
+	dur := c.expFn(ctx, info.IP)
+	if dur == SkipCache {
+		return
+	}
+	c.cache.Set(cacheutil.HostKey(host), info, dur)
+}
+
+// GetHost will retrieve the saved/cached hostname info and if not found then
+// a cache missed error, `ErrCacheMissed` will be returned
+// The hostname's entry holds the info, so the expFn is called with its ip on
+// every hit, and the hit is the miss if the expFn denies the ip
+func (c *_Cache) GetHost(ctx context.Context, host string) (*Info, error) {
+	if got, ok := c.cache.Get(cacheutil.HostKey(host)); ok {
+		info, ok := got.(*Info)
+		if ok && info != nil && c.expFn(ctx, info.IP) != SkipCache {
+			return info, nil
+		}
+	}
+	return nil, ErrCacheMissed
+}
+
+// expiryOf returns the cacheutil.Expiry of the fixed expiry duration and the
+// expFn, if provided
+func expiryOf(expiry time.Duration, expFn CacheExpiryFunction) cacheutil.Expiry {
+	e := cacheutil.Expiry{Expiry: expiry}
+	if expFn != nil {
+		e.ExpiryFn = func(ctx context.Context, ip net.IP) time.Duration {
+			return expFn(ctx, IP(ip))
+		}
+	}
+	return e
+}
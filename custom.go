@@ -16,6 +16,7 @@ package freeGeoIP
 
 import (
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"net"
 	"time"
@@ -52,6 +53,19 @@ func (ip *IP) MarshalJSON() ([]byte, error) {
 	return json.Marshal(ip.String())
 }
 
+func (ip *IP) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var _ip string
+	if err := d.DecodeElement(&_ip, &start); err != nil {
+		return wrapError("decoder", err)
+	}
+	*ip = ParseIP(_ip)
+	return nil
+}
+
+func (ip *IP) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(ip.String(), start)
+}
+
 // Location is a wrapper for *time.Location
 type Location time.Location
 
@@ -84,17 +98,44 @@ func (tz *Location) UnmarshalJSON(b []byte) error {
 	if err := json.Unmarshal(b, &zoneStr); err != nil {
 		return wrapError("decoder", err)
 	}
-	zone, err := time.LoadLocation(zoneStr)
+	zone, err := loadLocation(zoneStr)
 	if err != nil {
 		return wrapError("decoder", err)
 	}
-	if zone == nil {
-		return wrapError("decoder", errors.New("invalid timezone string"))
-	}
-	*tz = Location(*zone)
+	*tz = *zone
 	return nil
 }
 
 func (tz *Location) MarshalJSON() ([]byte, error) {
 	return json.Marshal(tz.String())
 }
+
+func (tz *Location) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var zoneStr string
+	if err := d.DecodeElement(&zoneStr, &start); err != nil {
+		return wrapError("decoder", err)
+	}
+	zone, err := loadLocation(zoneStr)
+	if err != nil {
+		return wrapError("decoder", err)
+	}
+	*tz = *zone
+	return nil
+}
+
+func (tz *Location) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(tz.String(), start)
+}
+
+// loadLocation returns the Location for the timezone name, the empty name
+// is the UTC
+func loadLocation(name string) (*Location, error) {
+	zone, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	if zone == nil {
+		return nil, errors.New("invalid timezone string")
+	}
+	return LocationF(zone), nil
+}
@@ -15,6 +15,7 @@
 package freeGeoIP_test
 
 import (
+	"encoding/xml"
 	"net"
 	"testing"
 	"time"
@@ -130,6 +131,158 @@ func TestDecoder(t *testing.T) {
 	}
 }
 
+func TestCSVDecoder(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    *freeGeoIP.Info
+		err     error
+		wantErr bool
+	}{
+		{
+			name:    "Nil Response",
+			data:    []byte(nil),
+			want:    nil,
+			err:     freeGeoIP.ErrNoResponse,
+			wantErr: true,
+		},
+		{
+			name:    "Empty IP Response",
+			data:    []byte(",,,,,,,,0,0,0\r\n"),
+			want:    nil,
+			err:     freeGeoIP.ErrNoResponse,
+			wantErr: true,
+		},
+		{
+			name:    "Missing Columns Response",
+			data:    []byte("2401:4900:16ff:f1ef:fff5:f63e:8a25:a38a,IN,India\r\n"),
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid Latitude Response",
+			data:    []byte("0.0.0.0,,,,,,,,north,0,0\r\n"),
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "Normal Response",
+			data:    []byte("2401:4900:16ff:f1ef:fff5:f63e:8a25:a38a,IN,India,KA,Karnataka,Belgaum,590006,Asia/Kolkata,15.8521,74.5045,0\r\n"),
+			want:    response(),
+			err:     nil,
+			wantErr: false,
+		},
+		{
+			name:    "Broadcast Response",
+			data:    []byte("0.0.0.0,,,,,,,,0,0,0\r\n"),
+			want:    broadcastResponse(),
+			err:     nil,
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := freeGeoIP.CSVDecoder(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CSVDecoder() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.err != nil && err != tt.err {
+				t.Errorf("CSVDecoder() error does not match got = %v, wantErr %v", err, tt.err)
+				return
+			}
+			_ = compare(t, got, tt.want)
+		})
+	}
+}
+
+func TestXMLDecoder(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    *freeGeoIP.Info
+		err     error
+		wantErr bool
+	}{
+		{
+			name:    "Nil Response",
+			data:    []byte(nil),
+			want:    nil,
+			err:     freeGeoIP.ErrNoResponse,
+			wantErr: true,
+		},
+		{
+			name:    "Empty Element Response",
+			data:    []byte(`<Response></Response>`),
+			want:    nil,
+			err:     freeGeoIP.ErrNoResponse,
+			wantErr: true,
+		},
+		{
+			name:    "Malformed Response",
+			data:    []byte(`<Response><IP>0.0.0.0</IP>`),
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name: "Normal Response",
+			data: []byte(`<Response>
+	<IP>2401:4900:16ff:f1ef:fff5:f63e:8a25:a38a</IP>
+	<CountryCode>IN</CountryCode>
+	<CountryName>India</CountryName>
+	<RegionCode>KA</RegionCode>
+	<RegionName>Karnataka</RegionName>
+	<City>Belgaum</City>
+	<ZipCode>590006</ZipCode>
+	<TimeZone>Asia/Kolkata</TimeZone>
+	<Latitude>15.8521</Latitude>
+	<Longitude>74.5045</Longitude>
+	<MetroCode>0</MetroCode>
+</Response>`),
+			want:    response(),
+			err:     nil,
+			wantErr: false,
+		},
+		{
+			name:    "Broadcast Response",
+			data:    []byte(`<Response><IP>0.0.0.0</IP><CountryCode></CountryCode><TimeZone></TimeZone><Latitude>0</Latitude><Longitude>0</Longitude><MetroCode>0</MetroCode></Response>`),
+			want:    broadcastResponse(),
+			err:     nil,
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := freeGeoIP.XMLDecoder(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("XMLDecoder() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.err != nil && err != tt.err {
+				t.Errorf("XMLDecoder() error does not match got = %v, wantErr %v", err, tt.err)
+				return
+			}
+			_ = compare(t, got, tt.want)
+		})
+	}
+}
+
+func TestInfoXMLRoundTrip(t *testing.T) {
+	for _, want := range []*freeGeoIP.Info{response(), broadcastResponse()} {
+		data, err := xml.Marshal(want)
+		if err != nil {
+			t.Fatalf("xml.Marshal() error = %v, want no error", err)
+		}
+		got := &freeGeoIP.Info{}
+		if err := xml.Unmarshal(data, got); err != nil {
+			t.Fatalf("xml.Unmarshal() error = %v, want no error", err)
+		}
+		if compare(t, got, want) {
+			return
+		}
+	}
+}
+
 // compare will compare the provided Info objects and fails the test
 // if got is different from want, and returns true if test fails
 func compare(t *testing.T, got, want *freeGeoIP.Info) bool {
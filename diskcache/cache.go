@@ -0,0 +1,734 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package diskcache is an embedded, append-only, log-structured on-disk
+// freeGeoIP.ICache, for the datasets larger than the memory. Only a compact
+// index of the entries is kept in the memory, the entries are appended to a
+// log file, replayed on open to recover from a crash, and compacted in the
+// background to drop the expired and the overwritten entries.
+package diskcache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP"
+	"github.com/Shivam010/go-freeGeoIP/internal/cacheutil"
+)
+
+const (
+	// DefaultCompactInterval is the interval of the compaction checks, used
+	// when Options.CompactInterval is not provided
+	DefaultCompactInterval = 10 * time.Minute
+	// DefaultCompactRatio is the fraction of the log file which must be
+	// garbage to compact it, used when Options.CompactRatio is not provided
+	DefaultCompactRatio = 0.5
+
+	// headerSize is the size of the record header: crc, kind, key length,
+	// value length and expiry
+	headerSize = 4 + 1 + 2 + 4 + 8
+	// readSize is the size of the first read of a record, enough for most of
+	// them, the rest is read only if it is larger
+	readSize = 512
+	// maxValueSize is the size of the largest value, a larger one is corrupt
+	maxValueSize = 1 << 24
+
+	// kindIP records are keyed by the 16 byte ip, and kindStr by a string,
+	// the hostnames and the empty ip of the caller
+	kindIP  = 0
+	kindStr = 1
+)
+
+// ErrCorrupt is returned when a record of the log can not be read back
+var ErrCorrupt = errors.New("diskcache: corrupt record")
+
+// Options are the options of the Cache.
+// Expiry and ExpiryFn are same as the expiry and the expFn of the
+// freeGeoIP.NewCache, if the Expiry is not provided the entries never expire
+// If CompactInterval or CompactRatio are not provided, DefaultCompactInterval
+// and DefaultCompactRatio are used
+type Options struct {
+	Expiry          time.Duration
+	ExpiryFn        freeGeoIP.CacheExpiryFunction
+	CompactInterval time.Duration
+	CompactRatio    float64
+}
+
+// Cache is the log-structured on-disk freeGeoIP.ICache, and
+// freeGeoIP.IHostCache. It is safe for the concurrent use, but the log file
+// must be used by a single Cache at a time. It must be closed with Close.
+// Its index keeps only the offset, the expiry and a hash of the key of every
+// record, 16 bytes per slot of a hash table kept at most 3/4 full, the keys
+// themselves are compared with the ones on the disk.
+type Cache struct {
+	path   string
+	opts   Options
+	expiry cacheutil.Expiry
+
+	// wmu serialises the appends and the compactions
+	wmu sync.Mutex
+	// mu guards the index, the file and its size
+	mu        sync.RWMutex
+	f         *os.File
+	size      int64
+	dead      int64
+	idx       index
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Open opens the Cache with its log file at path, creating it if it does not
+// exist. The log is replayed to build the index, and a partially written
+// record at its end, left by a crash, is truncated. If a record before it is
+// corrupt, ErrCorrupt is returned, and the log is left for the inspection.
+func Open(path string, opts Options) (*Cache, error) {
+	if opts.CompactInterval <= 0 {
+		opts.CompactInterval = DefaultCompactInterval
+	}
+	if opts.CompactRatio <= 0 {
+		opts.CompactRatio = DefaultCompactRatio
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		path: path,
+		opts: opts,
+		f:    f,
+		idx:  newIndex(0),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	c.expiry.Expiry = opts.Expiry
+	if fn := opts.ExpiryFn; fn != nil {
+		c.expiry.ExpiryFn = func(ctx context.Context, ip net.IP) time.Duration {
+			return fn(ctx, freeGeoIP.IP(ip))
+		}
+	}
+	if err := c.replay(); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	go c.loop()
+	return c, nil
+}
+
+// Len returns the number of the entries in the index, including the expired
+// ones not compacted yet
+func (c *Cache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.idx.count
+}
+
+// Size returns the size of the log file
+func (c *Cache) Size() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.size
+}
+
+// Set appends the info to the log, as per its expiry
+func (c *Cache) Set(ctx context.Context, info *freeGeoIP.Info) {
+	if info == nil {
+		return
+	}
+	kind, key := recordKey(info.IP)
+	c.set(ctx, kind, key, info)
+}
+
+// Get reads the cached ip info, if not found or expired then a cache missed
+// error, `freeGeoIP.ErrCacheMissed` will be returned
+// The error will also be returned when explicit cache miss is requested
+func (c *Cache) Get(ctx context.Context, ip freeGeoIP.IP) (*freeGeoIP.Info, error) {
+	if c.expiry.Skip(ctx, net.IP(ip)) {
+		return nil, freeGeoIP.ErrCacheMissed
+	}
+	kind, key := recordKey(ip)
+	return c.get(kind, key)
+}
+
+// SetHost appends the info to the log under the hostname, as per the expiry
+// of the info's ip
+func (c *Cache) SetHost(ctx context.Context, host string, info *freeGeoIP.Info) {
+	if info == nil {
+		return
+	}
+	c.set(ctx, kindStr, []byte(cacheutil.HostKey(host)), info)
+}
+
+// GetHost reads the cached hostname info, if not found or expired then a
+// cache missed error, `freeGeoIP.ErrCacheMissed` will be returned
+// The record is read back before the expiry of its ip is known, so the error
+// is also returned, after the read, if its ip is now explicitly denied
+func (c *Cache) GetHost(ctx context.Context, host string) (*freeGeoIP.Info, error) {
+	info, err := c.get(kindStr, []byte(cacheutil.HostKey(host)))
+	if err != nil || c.expiry.Skip(ctx, net.IP(info.IP)) {
+		return nil, freeGeoIP.ErrCacheMissed
+	}
+	return info, nil
+}
+
+// Close stops the background compaction, syncs and closes the log file
+func (c *Cache) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		<-c.done
+	})
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f == nil {
+		return nil
+	}
+	err := c.f.Sync()
+	if closeErr := c.f.Close(); err == nil {
+		err = closeErr
+	}
+	c.f = nil
+	return err
+}
+
+// recordKey returns the kind and the key of the ip's records
+func recordKey(ip freeGeoIP.IP) (byte, []byte) {
+	if key, ok := cacheutil.IPKey(net.IP(ip)); ok {
+		return kindIP, key[:]
+	}
+	return kindStr, []byte(ip.String())
+}
+
+func (c *Cache) set(ctx context.Context, kind byte, key []byte, info *freeGeoIP.Info) {
+	at, ok := c.expiry.At(ctx, net.IP(info.IP), time.Now())
+	if !ok {
+		return
+	}
+	var expires int64
+	if !at.IsZero() {
+		expires = at.UnixNano()
+	}
+	value, err := json.Marshal(info)
+	if err != nil {
+		return
+	}
+	record := encode(kind, key, value, expires)
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.mu.RLock()
+	f, off := c.f, c.size
+	c.mu.RUnlock()
+	if f == nil {
+		return
+	}
+	// the readers only read below the size, so they are not blocked while
+	// appending
+	if _, err := f.WriteAt(record, off); err != nil {
+		return
+	}
+	c.mu.Lock()
+	c.put(kind, key, off, expires, len(record))
+	c.size += int64(len(record))
+	c.mu.Unlock()
+}
+
+func (c *Cache) get(kind byte, key []byte) (*freeGeoIP.Info, error) {
+	now := time.Now().UnixNano()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.f == nil {
+		return nil, freeGeoIP.ErrCacheMissed
+	}
+	var r record
+	matches := match(c.f, kind, key, &r)
+	// the expired slot is skipped unread, the key is not found after it
+	_, ok := c.idx.find(hashKey(kind, key), func(s slot) bool {
+		return !s.expired(now) && matches(s)
+	})
+	if !ok || r.expired(now) {
+		return nil, freeGeoIP.ErrCacheMissed
+	}
+	info := &freeGeoIP.Info{}
+	if err := json.Unmarshal(r.value, info); err != nil {
+		return nil, ErrCorrupt
+	}
+	return info, nil
+}
+
+// put points the key to the record at off in the index, the lock must be
+// held and the record must be written
+func (c *Cache) put(kind byte, key []byte, off, expires int64, size int) {
+	var old record
+	h := hashKey(kind, key)
+	i, ok := c.idx.find(h, match(c.f, kind, key, &old))
+	if ok {
+		c.dead += int64(len(old.raw))
+	}
+	c.idx.put(i, ok, newSlot(off, h, expires))
+}
+
+// replay builds the index from the log, and truncates it after the last
+// complete record. A corrupt record is truncated only if it is the last one,
+// torn by a crash, otherwise ErrCorrupt is returned and the log is left as is.
+func (c *Cache) replay() error {
+	var off int64
+	err := scan(c.f, 0, -1, func(r record) error {
+		c.put(r.kind, r.key, off, r.expires, len(r.raw))
+		off += int64(len(r.raw))
+		return nil
+	})
+	if err == ErrCorrupt && !c.last(off) {
+		return ErrCorrupt
+	}
+	if err != nil && err != io.ErrUnexpectedEOF && err != ErrCorrupt {
+		return err
+	}
+	if err := c.f.Truncate(off); err != nil {
+		return err
+	}
+	c.size = off
+	return nil
+}
+
+// last reports whether the record at off ends at the end of the log, as per
+// its header
+func (c *Cache) last(off int64) bool {
+	st, err := c.f.Stat()
+	if err != nil {
+		return false
+	}
+	header := make([]byte, headerSize)
+	if _, err := c.f.ReadAt(header, off); err != nil {
+		return false
+	}
+	size, ok := recordSize(header)
+	return ok && off+int64(size) == st.Size()
+}
+
+// loop compacts the log every CompactInterval, if enough of it is garbage
+func (c *Cache) loop() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.opts.CompactInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			if c.garbage() >= c.opts.CompactRatio {
+				_ = c.Compact()
+			}
+		}
+	}
+}
+
+// garbage returns the fraction of the log, which is overwritten or expired.
+// The sizes of the expired records are not in the index, so they are taken
+// as the average size of the live ones.
+func (c *Cache) garbage() float64 {
+	now := time.Now().UnixNano()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.size == 0 || c.idx.count == 0 {
+		return 0
+	}
+	expired := 0
+	for _, s := range c.idx.slots {
+		if s.ref != 0 && s.expired(now) {
+			expired++
+		}
+	}
+	average := float64(c.size-c.dead) / float64(c.idx.count)
+	return (float64(c.dead) + float64(expired)*average) / float64(c.size)
+}
+
+// Compact rewrites the log with only the live records, dropping the expired
+// and the overwritten ones. The writes are blocked only while the records
+// appended during the compaction are copied over. The records are streamed
+// into the new log and its index, which is only swapped in at the end.
+func (c *Cache) Compact() error {
+	c.mu.RLock()
+	f, end, count := c.f, c.size, c.idx.count
+	c.mu.RUnlock()
+	if f == nil {
+		return errors.New("diskcache: closed")
+	}
+
+	tmpPath := c.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	// copy the live records, without blocking the writes
+	var (
+		idx     = newIndex(count)
+		w       = bufio.NewWriterSize(tmp, 1<<16)
+		written int64
+		off     int64
+		now     = time.Now().UnixNano()
+	)
+	err = scan(f, 0, end, func(r record) error {
+		from := off
+		off += int64(len(r.raw))
+		if r.expired(now) {
+			return nil
+		}
+		h := hashKey(r.kind, r.key)
+		c.mu.RLock()
+		ok := c.idx.live(h, from)
+		c.mu.RUnlock()
+		if !ok {
+			return nil
+		}
+		if _, err := w.Write(r.raw); err != nil {
+			return err
+		}
+		idx.insert(newSlot(written, h, r.expires))
+		written += int64(len(r.raw))
+		return nil
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	// copy the records appended meanwhile, and swap the logs. They may
+	// overwrite the copied ones, which are looked up in the new log.
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f != f {
+		return fail(errors.New("diskcache: closed"))
+	}
+	var dead int64
+	off = end
+	err = scan(f, end, c.size, func(r record) error {
+		from := off
+		off += int64(len(r.raw))
+		h := hashKey(r.kind, r.key)
+		if !c.idx.live(h, from) {
+			return nil
+		}
+		var old record
+		i, ok := idx.find(h, match(tmp, r.kind, r.key, &old))
+		if _, err := tmp.WriteAt(r.raw, written); err != nil {
+			return err
+		}
+		if ok {
+			dead += int64(len(old.raw))
+		}
+		idx.put(i, ok, newSlot(written, h, r.expires))
+		written += int64(len(r.raw))
+		return nil
+	})
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	c.f = nil
+	if err := os.Rename(tmpPath, c.path); err != nil {
+		_ = os.Remove(tmpPath)
+		c.f, _ = os.OpenFile(c.path, os.O_RDWR, 0644)
+		return err
+	}
+	if c.f, err = os.OpenFile(c.path, os.O_RDWR, 0644); err != nil {
+		return err
+	}
+	c.idx, c.size, c.dead = idx, written, dead
+	return nil
+}
+
+// slot is an entry of the index: the offset of the record plus one, zero if
+// the slot is empty, the hash of its key, and its expiry in unix seconds,
+// rounded up, and zero if it never expires
+type slot struct {
+	ref     uint64
+	hash    uint32
+	expires uint32
+}
+
+func newSlot(off int64, h uint32, expires int64) slot {
+	s := slot{ref: uint64(off) + 1, hash: h}
+	if expires > 0 {
+		sec := (expires + int64(time.Second) - 1) / int64(time.Second)
+		if sec > math.MaxUint32 {
+			sec = math.MaxUint32
+		}
+		s.expires = uint32(sec)
+	}
+	return s
+}
+
+func (s slot) off() int64 {
+	return int64(s.ref - 1)
+}
+
+func (s slot) expired(now int64) bool {
+	return s.expires != 0 && now >= int64(s.expires)*int64(time.Second)
+}
+
+// index is the open addressing hash table of the records, with the linear
+// probing. A key has at most one slot, so there are no deletions, the
+// overwritten and the expired records are dropped by the compaction.
+type index struct {
+	slots []slot
+	count int
+}
+
+// newIndex returns the index for n entries, before it grows
+func newIndex(n int) index {
+	size := 1024
+	for size*3/4 < n {
+		size *= 2
+	}
+	return index{slots: make([]slot, size)}
+}
+
+// find returns the position of the slot of the hash h, which matches, or of
+// the empty slot it is to be put at, and whether it matched
+func (x *index) find(h uint32, matches func(s slot) bool) (int, bool) {
+	mask := len(x.slots) - 1
+	for i := int(h) & mask; ; i = (i + 1) & mask {
+		s := x.slots[i]
+		if s.ref == 0 {
+			return i, false
+		}
+		if s.hash == h && matches(s) {
+			return i, true
+		}
+	}
+}
+
+// live reports whether the record at off, of the hash h, is the current one
+// of its key, as a record is only pointed to by the slot of its own key
+func (x *index) live(h uint32, off int64) bool {
+	mask := len(x.slots) - 1
+	for i := int(h) & mask; x.slots[i].ref != 0; i = (i + 1) & mask {
+		if s := x.slots[i]; s.hash == h && s.off() == off {
+			return true
+		}
+	}
+	return false
+}
+
+// put puts the slot at the position returned by find, and grows the index
+// if it got more than 3/4 full
+func (x *index) put(i int, found bool, s slot) {
+	x.slots[i] = s
+	if found {
+		return
+	}
+	x.count++
+	if x.count*4 <= len(x.slots)*3 {
+		return
+	}
+	old := x.slots
+	x.slots = make([]slot, 2*len(old))
+	x.count = 0
+	for _, s := range old {
+		if s.ref != 0 {
+			x.insert(s)
+		}
+	}
+}
+
+// insert puts the slot of a key not in the index yet
+func (x *index) insert(s slot) {
+	i, _ := x.find(s.hash, func(slot) bool { return false })
+	x.put(i, false, s)
+}
+
+// hashKey returns the hash of the key of the kind, the FNV-1a finalised with
+// the avalanche of the murmur3, as the index uses its low bits
+func hashKey(kind byte, key []byte) uint32 {
+	h := uint32(2166136261)
+	h = (h ^ uint32(kind)) * 16777619
+	for _, b := range key {
+		h = (h ^ uint32(b)) * 16777619
+	}
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// match returns the matcher of the slot of the key, its record is read back
+// from f and stored in r
+func match(f *os.File, kind byte, key []byte, r *record) func(s slot) bool {
+	return func(s slot) bool {
+		got, err := readAt(f, s.off())
+		if err != nil || got.kind != kind || !bytes.Equal(got.key, key) {
+			return false
+		}
+		*r = got
+		return true
+	}
+}
+
+// record is a decoded log record, raw is the whole encoded record and
+// expires is in unix nanoseconds, zero if it never expires
+type record struct {
+	kind    byte
+	key     []byte
+	value   []byte
+	expires int64
+	raw     []byte
+}
+
+func (r record) expired(now int64) bool {
+	return r.expires != 0 && now >= r.expires
+}
+
+// encode encodes the record as: crc, kind, key length, value length, expiry,
+// key and value, the crc covers everything after it
+func encode(kind byte, key, value []byte, expires int64) []byte {
+	buf := make([]byte, headerSize+len(key)+len(value))
+	buf[4] = kind
+	binary.LittleEndian.PutUint16(buf[5:], uint16(len(key)))
+	binary.LittleEndian.PutUint32(buf[7:], uint32(len(value)))
+	binary.LittleEndian.PutUint64(buf[11:], uint64(expires))
+	copy(buf[headerSize:], key)
+	copy(buf[headerSize+len(key):], value)
+	binary.LittleEndian.PutUint32(buf, crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
+
+// recordSize returns the size of the whole record from its header, false if
+// it is corrupt
+func recordSize(header []byte) (int, bool) {
+	keyLen := int(binary.LittleEndian.Uint16(header[5:]))
+	valLen := int(binary.LittleEndian.Uint32(header[7:]))
+	return headerSize + keyLen + valLen, valLen <= maxValueSize
+}
+
+// decode decodes the whole encoded record, false if it is corrupt
+func decode(buf []byte) (record, bool) {
+	if len(buf) < headerSize || binary.LittleEndian.Uint32(buf) != crc32.ChecksumIEEE(buf[4:]) {
+		return record{}, false
+	}
+	if size, ok := recordSize(buf); !ok || size != len(buf) {
+		return record{}, false
+	}
+	keyLen := int(binary.LittleEndian.Uint16(buf[5:]))
+	return record{
+		kind:    buf[4],
+		key:     buf[headerSize : headerSize+keyLen],
+		value:   buf[headerSize+keyLen:],
+		expires: int64(binary.LittleEndian.Uint64(buf[11:])),
+		raw:     buf,
+	}, true
+}
+
+// readAt reads the record at off, with a single read for most of them
+func readAt(f *os.File, off int64) (record, error) {
+	buf := make([]byte, readSize)
+	n, err := f.ReadAt(buf, off)
+	if n < headerSize {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return record{}, err
+	}
+	size, ok := recordSize(buf)
+	if !ok {
+		return record{}, ErrCorrupt
+	}
+	if size > n {
+		if n < len(buf) {
+			return record{}, io.ErrUnexpectedEOF
+		}
+		buf = append(buf, make([]byte, size-n)...)
+		if _, err := f.ReadAt(buf[n:], off+int64(n)); err != nil {
+			return record{}, io.ErrUnexpectedEOF
+		}
+	}
+	r, ok := decode(buf[:size])
+	if !ok {
+		return record{}, ErrCorrupt
+	}
+	return r, nil
+}
+
+// scan calls fn with every record of the log between the offsets, the
+// negative end is the end of the file. The record is only valid during the
+// call, its buffer is reused. It returns io.ErrUnexpectedEOF or ErrCorrupt at
+// a partially written or a corrupt record.
+func scan(f *os.File, start, end int64, fn func(r record) error) error {
+	var r io.Reader = io.NewSectionReader(f, start, 1<<62)
+	if end >= 0 {
+		r = io.NewSectionReader(f, start, end-start)
+	}
+	br := bufio.NewReaderSize(r, 1<<16)
+	buf := make([]byte, readSize)
+	for {
+		if _, err := io.ReadFull(br, buf[:headerSize]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return io.ErrUnexpectedEOF
+		}
+		size, ok := recordSize(buf)
+		if !ok {
+			return ErrCorrupt
+		}
+		if size > cap(buf) {
+			buf = append(buf[:headerSize], make([]byte, size-headerSize)...)
+		}
+		if _, err := io.ReadFull(br, buf[headerSize:size]); err != nil {
+			return io.ErrUnexpectedEOF
+		}
+		rec, ok := decode(buf[:size])
+		if !ok {
+			return ErrCorrupt
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,401 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package diskcache_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP"
+	"github.com/Shivam010/go-freeGeoIP/diskcache"
+)
+
+// tempLog returns the path of a log file in a new temporary directory, and
+// the function to remove it
+func tempLog(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "diskcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "cache.log"), func() { _ = os.RemoveAll(dir) }
+}
+
+// ipInfo returns the Info of the ith test ip
+func ipInfo(i int, city string) *freeGeoIP.Info {
+	zone, _ := time.LoadLocation("Asia/Kolkata")
+	return &freeGeoIP.Info{
+		IP:       freeGeoIP.ParseIP("10.0." + strconv.Itoa(i/256) + "." + strconv.Itoa(i%256)),
+		City:     city,
+		TimeZone: freeGeoIP.LocationF(zone),
+		Latitude: 15.8521,
+	}
+}
+
+func TestCache(t *testing.T) {
+	path, cleanup := tempLog(t)
+	defer cleanup()
+	ctx := context.Background()
+	opts := diskcache.Options{
+		Expiry: time.Hour,
+		ExpiryFn: func(ctx context.Context, ip freeGeoIP.IP) time.Duration {
+			switch ip.String() {
+			case "10.0.0.1":
+				return freeGeoIP.SkipCache
+			case "10.0.0.2":
+				return 10 * time.Millisecond
+			}
+			return 0 // the Expiry
+		},
+	}
+
+	cache, err := diskcache.Open(path, opts)
+	if err != nil {
+		t.Fatalf("Open() error = %v, want no error", err)
+	}
+	for i := 0; i < 3; i++ {
+		cache.Set(ctx, ipInfo(i, "Belgaum"))
+	}
+	cache.Set(ctx, &freeGeoIP.Info{CountryCode: "IN"}) // the ip of the caller
+	cache.SetHost(ctx, "example.com", ipInfo(0, "Belgaum"))
+
+	got, err := cache.Get(ctx, ipInfo(0, "").IP)
+	if err != nil || got.City != "Belgaum" || got.TimeZone.String() != "Asia/Kolkata" || got.Latitude != 15.8521 {
+		t.Fatalf("Get() got = %+v, %v", got, err)
+	}
+	if got, err := cache.Get(ctx, nil); err != nil || got.CountryCode != "IN" {
+		t.Fatalf("Get() got = %+v, %v", got, err)
+	}
+	if got, err := cache.GetHost(ctx, "example.com"); err != nil || got.City != "Belgaum" {
+		t.Fatalf("GetHost() got = %+v, %v", got, err)
+	}
+	if _, err := cache.Get(ctx, ipInfo(1, "").IP); err != freeGeoIP.ErrCacheMissed {
+		t.Fatalf("Get() error = %v, want %v", err, freeGeoIP.ErrCacheMissed)
+	}
+	time.Sleep(15 * time.Millisecond)
+	if _, err := cache.Get(ctx, ipInfo(2, "").IP); err != freeGeoIP.ErrCacheMissed {
+		t.Fatalf("Get() of expired error = %v, want %v", err, freeGeoIP.ErrCacheMissed)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Close() error = %v, want no error", err)
+	}
+
+	// reopened from the log
+	cache, err = diskcache.Open(path, opts)
+	if err != nil {
+		t.Fatalf("Open() error = %v, want no error", err)
+	}
+	defer cache.Close()
+	if got, err := cache.Get(ctx, ipInfo(0, "").IP); err != nil || got.City != "Belgaum" {
+		t.Fatalf("Get() got = %+v, %v", got, err)
+	}
+	if got, err := cache.GetHost(ctx, "example.com"); err != nil || got.City != "Belgaum" {
+		t.Fatalf("GetHost() got = %+v, %v", got, err)
+	}
+}
+
+func TestCacheCrashRecovery(t *testing.T) {
+	path, cleanup := tempLog(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	cache, err := diskcache.Open(path, diskcache.Options{})
+	if err != nil {
+		t.Fatalf("Open() error = %v, want no error", err)
+	}
+	cache.Set(ctx, ipInfo(0, "Belgaum"))
+	cache.Set(ctx, ipInfo(1, "Belgaum"))
+	size := cache.Size()
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Close() error = %v, want no error", err)
+	}
+
+	// a crash in the middle of an append
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte{1, 2, 3, 4, 0, 16, 0, 200, 0, 0, 0, 0, 0, 0})
+	_ = f.Close()
+
+	cache, err = diskcache.Open(path, diskcache.Options{})
+	if err != nil {
+		t.Fatalf("Open() error = %v, want no error", err)
+	}
+	defer cache.Close()
+	if cache.Size() != size || cache.Len() != 2 {
+		t.Fatalf("Size(), Len() got = %v, %v, want %v, %v", cache.Size(), cache.Len(), size, 2)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := cache.Get(ctx, ipInfo(i, "").IP); err != nil {
+			t.Fatalf("Get() error = %v, want no error", err)
+		}
+	}
+	// appends continue after the last complete record
+	cache.Set(ctx, ipInfo(2, "Belgaum"))
+	if _, err := cache.Get(ctx, ipInfo(2, "").IP); err != nil {
+		t.Fatalf("Get() error = %v, want no error", err)
+	}
+}
+
+func TestCacheCorruption(t *testing.T) {
+	path, cleanup := tempLog(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	cache, err := diskcache.Open(path, diskcache.Options{})
+	if err != nil {
+		t.Fatalf("Open() error = %v, want no error", err)
+	}
+	for i := 0; i < 100; i++ {
+		cache.Set(ctx, ipInfo(i, "Belgaum"))
+	}
+	size := cache.Size()
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Close() error = %v, want no error", err)
+	}
+	flip := func(off int64) {
+		f, err := os.OpenFile(path, os.O_RDWR, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 1)
+		_, _ = f.ReadAt(b, off)
+		b[0] ^= 0xff
+		_, _ = f.WriteAt(b, off)
+		_ = f.Close()
+	}
+
+	// a corrupt record in the middle is not truncated with the rest
+	flip(300)
+	if _, err := diskcache.Open(path, diskcache.Options{}); err != diskcache.ErrCorrupt {
+		t.Fatalf("Open() error = %v, want %v", err, diskcache.ErrCorrupt)
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Size() != size {
+		t.Fatalf("log size got = %v, want %v", st.Size(), size)
+	}
+
+	// a corrupt last record is torn by a crash, only it is truncated
+	flip(300)
+	flip(size - 1)
+	cache, err = diskcache.Open(path, diskcache.Options{})
+	if err != nil {
+		t.Fatalf("Open() error = %v, want no error", err)
+	}
+	defer cache.Close()
+	if cache.Len() != 99 || cache.Size() >= size {
+		t.Fatalf("Len(), Size() got = %v, %v, want %v, less than %v", cache.Len(), cache.Size(), 99, size)
+	}
+	if _, err := cache.Get(ctx, ipInfo(98, "").IP); err != nil {
+		t.Fatalf("Get() error = %v, want no error", err)
+	}
+}
+
+func TestCacheCompact(t *testing.T) {
+	path, cleanup := tempLog(t)
+	defer cleanup()
+	ctx := context.Background()
+	opts := diskcache.Options{
+		ExpiryFn: func(ctx context.Context, ip freeGeoIP.IP) time.Duration {
+			if ip.String() == "10.0.0.99" {
+				return time.Millisecond
+			}
+			return freeGeoIP.NoCacheExpiration
+		},
+	}
+	cache, err := diskcache.Open(path, opts)
+	if err != nil {
+		t.Fatalf("Open() error = %v, want no error", err)
+	}
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 50; i++ {
+			cache.Set(ctx, ipInfo(i, "City "+strconv.Itoa(round)))
+		}
+	}
+	cache.Set(ctx, ipInfo(99, "Expired"))
+	time.Sleep(5 * time.Millisecond)
+	before := cache.Size()
+
+	// concurrent use during the compaction
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 50; i < 100; i++ {
+			if i == 99 {
+				continue
+			}
+			cache.Set(ctx, ipInfo(i, "New"))
+			if got, err := cache.Get(ctx, ipInfo(i%50, "").IP); err != nil || got.City != "City 9" {
+				t.Errorf("Get() got = %+v, %v", got, err)
+			}
+		}
+	}()
+	if err := cache.Compact(); err != nil {
+		t.Fatalf("Compact() error = %v, want no error", err)
+	}
+	wg.Wait()
+
+	if cache.Size() >= before/5 {
+		t.Fatalf("Size() got = %v, want less than %v", cache.Size(), before/5)
+	}
+	check := func(cache *diskcache.Cache) {
+		for i := 0; i < 99; i++ {
+			want := "City 9"
+			if i >= 50 {
+				want = "New"
+			}
+			if got, err := cache.Get(ctx, ipInfo(i, "").IP); err != nil || got.City != want {
+				t.Fatalf("Get(%v) got = %+v, %v, want %v", i, got, err, want)
+			}
+		}
+		if _, err := cache.Get(ctx, ipInfo(99, "").IP); err != freeGeoIP.ErrCacheMissed {
+			t.Fatalf("Get() of expired error = %v, want %v", err, freeGeoIP.ErrCacheMissed)
+		}
+		if cache.Len() != 99 {
+			t.Fatalf("Len() got = %v, want %v", cache.Len(), 99)
+		}
+	}
+	check(cache)
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Close() error = %v, want no error", err)
+	}
+
+	// the compacted log is replayed
+	cache, err = diskcache.Open(path, opts)
+	if err != nil {
+		t.Fatalf("Open() error = %v, want no error", err)
+	}
+	defer cache.Close()
+	check(cache)
+}
+
+func TestCacheCompactOverwrite(t *testing.T) {
+	path, cleanup := tempLog(t)
+	defer cleanup()
+	ctx := context.Background()
+	cache, err := diskcache.Open(path, diskcache.Options{})
+	if err != nil {
+		t.Fatalf("Open() error = %v, want no error", err)
+	}
+	// more entries than the initial index holds
+	const n = 3000
+	for i := 0; i < n; i++ {
+		cache.Set(ctx, ipInfo(i, "Old"))
+	}
+
+	// the copied records are overwritten during the compaction
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < n; i += 3 {
+			cache.Set(ctx, ipInfo(i, "New"))
+		}
+	}()
+	if err := cache.Compact(); err != nil {
+		t.Fatalf("Compact() error = %v, want no error", err)
+	}
+	wg.Wait()
+
+	check := func(cache *diskcache.Cache) {
+		if cache.Len() != n {
+			t.Fatalf("Len() got = %v, want %v", cache.Len(), n)
+		}
+		for i := 0; i < n; i++ {
+			want := "Old"
+			if i%3 == 0 {
+				want = "New"
+			}
+			if got, err := cache.Get(ctx, ipInfo(i, "").IP); err != nil || got.City != want {
+				t.Fatalf("Get(%v) got = %+v, %v, want %v", i, got, err, want)
+			}
+		}
+	}
+	check(cache)
+	if err := cache.Compact(); err != nil {
+		t.Fatalf("Compact() error = %v, want no error", err)
+	}
+	check(cache)
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Close() error = %v, want no error", err)
+	}
+	cache, err = diskcache.Open(path, diskcache.Options{})
+	if err != nil {
+		t.Fatalf("Open() error = %v, want no error", err)
+	}
+	defer cache.Close()
+	check(cache)
+}
+
+func TestCacheBackgroundCompaction(t *testing.T) {
+	path, cleanup := tempLog(t)
+	defer cleanup()
+	ctx := context.Background()
+	cache, err := diskcache.Open(path, diskcache.Options{CompactInterval: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Open() error = %v, want no error", err)
+	}
+	defer cache.Close()
+	for i := 0; i < 20; i++ {
+		cache.Set(ctx, ipInfo(0, "City "+strconv.Itoa(i)))
+	}
+	deadline := time.Now().Add(time.Second)
+	for cache.Len() != 1 || cache.Size() > 500 {
+		if time.Now().After(deadline) {
+			t.Fatalf("log was not compacted, size = %v", cache.Size())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got, err := cache.Get(ctx, ipInfo(0, "").IP); err != nil || got.City != "City 19" {
+		t.Fatalf("Get() got = %+v, %v", got, err)
+	}
+}
+
+// belgaum is the Provider which locates every ip in Belgaum
+type belgaum struct{}
+
+func (belgaum) Lookup(ctx context.Context, ip freeGeoIP.IP) (*freeGeoIP.Info, *freeGeoIP.MetaInfo, error) {
+	info := ipInfo(0, "Belgaum")
+	info.IP = ip
+	return info, nil, nil
+}
+
+func TestCacheClient(t *testing.T) {
+	path, cleanup := tempLog(t)
+	defer cleanup()
+	cache, err := diskcache.Open(path, diskcache.Options{})
+	if err != nil {
+		t.Fatalf("Open() error = %v, want no error", err)
+	}
+	defer cache.Close()
+	cli := &freeGeoIP.Client{Cache: cache, Provider: belgaum{}}
+	ctx := context.Background()
+	if res := cli.GetGeoInfo(ctx, ipInfo(0, "").IP); res.Error != nil || res.Cached {
+		t.Fatalf("GetGeoInfo() got = %+v", res)
+	}
+	if res := cli.GetGeoInfo(ctx, ipInfo(0, "").IP); res.Error != nil || !res.Cached || res.Info.City != "Belgaum" {
+		t.Fatalf("GetGeoInfo() got = %+v", res)
+	}
+}
@@ -23,6 +23,7 @@ const (
 	ErrInternal     = _Error("freeGeoIp: something went wrong")
 	ErrLimitReached = _Error("freeGeoIp: api limit reached")
 	ErrNoResponse   = _Error("freeGeoIp: no information found")
+	ErrInvalidKey   = _Error("freeGeoIp: invalid api key")
 	ErrCacheMissed  = _Error("cache: info not found")
 )
 
@@ -0,0 +1,147 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Backend is a named Provider, the Name is reported in MetaInfo.Provider of
+// the responses answered by it
+type Backend struct {
+	Name     string
+	Provider Provider
+}
+
+// name returns the Name of the Backend, or its position if not provided
+func (b Backend) name(i int) string {
+	if b.Name != "" {
+		return b.Name
+	}
+	return "backend-" + strconv.Itoa(i)
+}
+
+// FailoverProvider is the Provider that tries the ordered list of Backends,
+// until one of them answers. The backends which reached their API limit are
+// skipped until their quota resets.
+// If ShouldFailover is not provided, every error other than `ErrNoResponse`
+// and the context errors fails over to the next backend.
+type FailoverProvider struct {
+	Backends       []Backend
+	ShouldFailover func(err error) bool
+
+	skips skipList
+}
+
+// NewFailoverProvider returns the FailoverProvider for the ordered backends
+func NewFailoverProvider(backends ...Backend) *FailoverProvider {
+	return &FailoverProvider{Backends: backends}
+}
+
+// Lookup looks up the ip with the first available backend, see Provider
+func (f *FailoverProvider) Lookup(ctx context.Context, ip IP) (*Info, *MetaInfo, error) {
+	var (
+		lastErr  error = ErrNoResponse
+		lastMeta *MetaInfo
+		earliest time.Time
+		tried    bool
+	)
+	for i, b := range f.Backends {
+		if until, ok := f.skips.skipped(i, time.Now()); ok {
+			if earliest.IsZero() || until.Before(earliest) {
+				earliest = until
+			}
+			continue
+		}
+		tried = true
+		info, meta, err := b.Provider.Lookup(ctx, ip)
+		f.skips.observe(i, meta, err)
+		meta = namedMeta(meta, b.name(i))
+		if err == nil {
+			return info, meta, nil
+		}
+		lastErr, lastMeta = err, meta
+		if ctx.Err() != nil || !shouldFailover(f.ShouldFailover, err) {
+			break
+		}
+	}
+	if !tried {
+		return nil, &MetaInfo{ResetIn: time.Until(earliest), ResetAt: earliest}, ErrLimitReached
+	}
+	return nil, lastMeta, lastErr
+}
+
+// shouldFailover reports whether the next provider should be tried for err,
+// as per fn if provided, otherwise for every error other than `ErrNoResponse`
+// and the context errors
+func shouldFailover(fn func(err error) bool, err error) bool {
+	if fn != nil {
+		return fn(err)
+	}
+	return err != ErrNoResponse && err != context.Canceled && err != context.DeadlineExceeded
+}
+
+// skipList tracks the providers which reached their API limit, by their
+// position, to skip them until their quota resets. Its zero value is ready
+// to use, and it is safe for the concurrent use.
+type skipList struct {
+	mu    sync.Mutex
+	until map[int]time.Time
+}
+
+// skipped reports whether the ith provider is rate limited, and till when
+func (s *skipList) skipped(i int, now time.Time) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	until, ok := s.until[i]
+	if ok && !until.After(now) {
+		delete(s.until, i)
+		return time.Time{}, false
+	}
+	return until, ok
+}
+
+// observe marks the ith provider rate limited, if it reached its limit or its
+// quota is exhausted
+func (s *skipList) observe(i int, meta *MetaInfo, err error) {
+	now := time.Now()
+	exhausted := meta != nil && meta.ResetAt.After(now) && meta.Limit > 0 && meta.Remaining <= 0
+	if err != ErrLimitReached && !exhausted {
+		return
+	}
+	until := now.Add(DefaultQuotaWindow)
+	if meta != nil && meta.ResetAt.After(now) {
+		until = meta.ResetAt
+	}
+	s.mu.Lock()
+	if s.until == nil {
+		s.until = map[int]time.Time{}
+	}
+	s.until[i] = until
+	s.mu.Unlock()
+}
+
+// namedMeta returns a copy of meta with the name of the Provider
+func namedMeta(meta *MetaInfo, name string) *MetaInfo {
+	named := MetaInfo{}
+	if meta != nil {
+		named = *meta
+	}
+	named.Provider = name
+	return &named
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP"
+)
+
+func TestFailoverProvider(t *testing.T) {
+	limited := &tableProvider{
+		err:  freeGeoIP.ErrLimitReached,
+		meta: &freeGeoIP.MetaInfo{Limit: 10, ResetAt: time.Now().Add(time.Hour)},
+	}
+	down := &tableProvider{err: errors.New("http: connection refused")}
+	working := &tableProvider{table: map[string]*freeGeoIP.Info{
+		responseIP:  response(),
+		broadcastIP: broadcastResponse(),
+	}}
+	cli := &freeGeoIP.Client{Provider: freeGeoIP.NewFailoverProvider(
+		freeGeoIP.Backend{Name: "limited", Provider: limited},
+		freeGeoIP.Backend{Name: "down", Provider: down},
+		freeGeoIP.Backend{Name: "working", Provider: working},
+	)}
+	ctx := context.Background()
+
+	res := cli.GetGeoInfoFromString(ctx, responseIP)
+	if res.Error != nil {
+		t.Fatalf("GetGeoInfoFromString() error = %v, want no error", res.Error)
+	}
+	if res.Meta.Provider != "working" {
+		t.Fatalf("GetGeoInfoFromString() provider got = %v, want %v", res.Meta.Provider, "working")
+	}
+	compare(t, res.Info, response())
+
+	// rate limited backend must be skipped till its reset
+	res = cli.GetGeoInfoFromString(ctx, broadcastIP)
+	if res.Error != nil || res.Meta.Provider != "working" {
+		t.Fatalf("GetGeoInfoFromString() got = %+v, want response from %v", res, "working")
+	}
+	if limited.Calls() != 1 || down.Calls() != 2 || working.Calls() != 2 {
+		t.Fatalf("Lookup() calls got = %v, %v, %v, want 1, 2, 2", limited.Calls(), down.Calls(), working.Calls())
+	}
+
+	// no information found must not fail over
+	if res = cli.GetGeoInfoFromString(ctx, dnsIP); res.Error != freeGeoIP.ErrNoResponse {
+		t.Fatalf("GetGeoInfoFromString() error = %v, want %v", res.Error, freeGeoIP.ErrNoResponse)
+	}
+}
+
+func TestFailoverProvider_AllLimited(t *testing.T) {
+	reset := time.Now().Add(time.Minute)
+	limited := &tableProvider{
+		err:  freeGeoIP.ErrLimitReached,
+		meta: &freeGeoIP.MetaInfo{Limit: 10, ResetAt: reset},
+	}
+	provider := freeGeoIP.NewFailoverProvider(freeGeoIP.Backend{Provider: limited})
+
+	_, meta, err := provider.Lookup(context.Background(), freeGeoIP.ParseIP(dnsIP))
+	if err != freeGeoIP.ErrLimitReached || meta.Provider != "backend-0" {
+		t.Fatalf("Lookup() got = %+v, %v, want %v from %v", meta, err, freeGeoIP.ErrLimitReached, "backend-0")
+	}
+	_, meta, err = provider.Lookup(context.Background(), freeGeoIP.ParseIP(dnsIP))
+	if err != freeGeoIP.ErrLimitReached || !meta.ResetAt.Equal(reset) {
+		t.Fatalf("Lookup() got = %+v, %v, want %v till %v", meta, err, freeGeoIP.ErrLimitReached, reset)
+	}
+	if limited.Calls() != 1 {
+		t.Fatalf("Lookup() calls got = %v, want %v", limited.Calls(), 1)
+	}
+}
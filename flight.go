@@ -0,0 +1,111 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// flightCall is an in-flight lookup shared by all of its waiters
+type flightCall struct {
+	done    chan struct{}
+	res     Response
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// flightGroup collapses the concurrent lookups for the same key into a single
+// lookup, its zero value is ready to use
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// do executes fn only once for all the concurrent callers of the same key and
+// returns its response to every one of them, shared reports whether the call
+// was joined by more than one caller.
+// fn runs with a context detached from the caller's cancellation, i.e. one
+// waiter leaving does not cancel the lookup for the others, only when every
+// waiter has left the lookup is cancelled.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(context.Context) Response) (res Response, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*flightCall{}
+	}
+	call, ok := g.calls[key]
+	if !ok {
+		fctx, cancel := context.WithCancel(detachedContext{ctx})
+		call = &flightCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = call
+		go func() {
+			call.res = fn(fctx)
+			g.mu.Lock()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+			cancel()
+			close(call.done)
+		}()
+	}
+	call.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		g.mu.Lock()
+		shared = call.waiters > 1
+		g.mu.Unlock()
+		return call.res, shared
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// nobody is waiting anymore, new callers must not join it
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+			call.cancel()
+		}
+		g.mu.Unlock()
+		return Response{Error: wrapError("http", ctx.Err())}, false
+	}
+}
+
+// detachedContext carries the values of its parent context, but is never
+// cancelled or timed out with it. The parent's deadline is still available to
+// the RateLimiter, under the callerDeadlineKey, to fail the lookups which can
+// not be paced before it.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (detachedContext) Done() <-chan struct{} { return nil }
+
+func (detachedContext) Err() error { return nil }
+
+func (d detachedContext) Value(key interface{}) interface{} {
+	if _, ok := key.(callerDeadlineKey); ok {
+		if deadline, ok := callerDeadline(d.parent); ok {
+			return deadline
+		}
+		return nil
+	}
+	return d.parent.Value(key)
+}
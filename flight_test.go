@@ -0,0 +1,85 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP_test
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP"
+)
+
+func TestConcurrentLookupsAreCollapsed(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		_, _ = w.Write([]byte(`{"ip":"` + dnsIP + `","time_zone":""}`))
+	}))
+	defer srv.Close()
+
+	cli := &freeGeoIP.Client{
+		Cache:   freeGeoIP.NoopCache{},
+		HttpCli: srv.Client(),
+		Logger:  log.New(ioutil.Discard, "", 0),
+		BaseURL: srv.URL,
+	}
+
+	// a waiter leaving must not cancel the call for the others
+	cancelled, cancel := context.WithCancel(context.Background())
+	left := make(chan freeGeoIP.Response)
+	go func() { left <- cli.GetGeoInfoFromString(cancelled, dnsIP) }()
+
+	const waiters = 10
+	var wg sync.WaitGroup
+	responses := make([]freeGeoIP.Response, waiters)
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = cli.GetGeoInfoFromString(context.Background(), dnsIP)
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if res := <-left; res.Error == nil {
+		t.Fatalf("GetGeoInfoFromString() for cancelled context must return error")
+	}
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("API calls got = %v, want %v", calls, 1)
+	}
+	for i, res := range responses {
+		if res.Error != nil {
+			t.Fatalf("GetGeoInfoFromString()[%v] error = %v, want no error", i, res.Error)
+		}
+		if res.Info.IP.String() != dnsIP {
+			t.Fatalf("GetGeoInfoFromString()[%v] ip got = %v, want %v", i, res.Info.IP, dnsIP)
+		}
+		if i > 0 && res.Info == responses[0].Info {
+			t.Fatalf("GetGeoInfoFromString() waiters must not share the Info")
+		}
+	}
+}
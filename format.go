@@ -0,0 +1,102 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+)
+
+// Format is the response format of the API
+type Format string
+
+const (
+	// FormatJSON is the json response format, decoded with Decoder
+	FormatJSON Format = "json"
+	// FormatCSV is the csv response format, decoded with CSVDecoder
+	FormatCSV Format = "csv"
+	// FormatXML is the xml response format, decoded with XMLDecoder
+	FormatXML Format = "xml"
+)
+
+// decoders maps the supported formats to their decoders
+var decoders = map[Format]func([]byte) (*Info, error){
+	FormatJSON: Decoder,
+	FormatCSV:  CSVDecoder,
+	FormatXML:  XMLDecoder,
+}
+
+// format returns the Format of the HTTPProvider, FormatJSON if not provided
+func (p *HTTPProvider) format() Format {
+	if p.Format == "" {
+		return FormatJSON
+	}
+	return p.Format
+}
+
+// csvColumns is the number of the columns in the csv response, in order:
+// ip, country_code, country_name, region_code, region_name, city, zip_code,
+// time_zone, latitude, longitude and metro_code
+const csvColumns = 11
+
+// CSVDecoder decodes the csv response of the API, a single row of the
+// positional columns, into the Info
+func CSVDecoder(data []byte) (*Info, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, ErrNoResponse
+	}
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	record, err := r.Read()
+	if err == io.EOF {
+		return nil, ErrNoResponse
+	}
+	if err != nil {
+		return nil, wrapError("decode", err)
+	}
+	if len(record) < csvColumns {
+		return nil, wrapError("decode", errors.New("expected "+strconv.Itoa(csvColumns)+
+			" columns, got "+strconv.Itoa(len(record))))
+	}
+
+	info := &Info{
+		IP:          ParseIP(record[0]),
+		CountryCode: record[1],
+		CountryName: record[2],
+		RegionCode:  record[3],
+		RegionName:  record[4],
+		City:        record[5],
+		ZipCode:     record[6],
+	}
+	if len(info.IP) == 0 {
+		return nil, ErrNoResponse
+	}
+	if info.TimeZone, err = loadLocation(record[7]); err != nil {
+		return nil, wrapError("decode", err)
+	}
+	floats := []*float64{&info.Latitude, &info.Longitude, &info.MetroCode}
+	for i, col := range record[8:csvColumns] {
+		if col == "" {
+			continue
+		}
+		if *floats[i], err = strconv.ParseFloat(col, 64); err != nil {
+			return nil, wrapError("decode", err)
+		}
+	}
+	return info, nil
+}
@@ -0,0 +1,244 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freegeoiptest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/Shivam010/go-freeGeoIP"
+)
+
+// ErrNotRecorded is returned by the Recorder replaying a cassette, for the
+// requests not recorded in it
+var ErrNotRecorded = errors.New("freegeoiptest: request is not recorded in the cassette")
+
+// CassetteMode is the mode of the Recorder
+type CassetteMode int
+
+const (
+	// CassetteAuto replays the cassette if it exists, otherwise records it
+	CassetteAuto CassetteMode = iota
+	// CassetteRecord always records the cassette, overwriting the existing one
+	CassetteRecord
+	// CassetteReplay always replays the cassette, and never calls the API
+	CassetteReplay
+)
+
+// Interaction is a recorded request and its response. The request is matched
+// by its method, path and query, which have the response format, and the
+// response is replayed byte-for-byte.
+type Interaction struct {
+	Method string      `json:"method"`
+	Path   string      `json:"path"`
+	Query  string      `json:"query,omitempty"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body"`
+	// Binary reports whether the Body is base64 encoded, for the bodies which
+	// are not utf-8
+	Binary bool `json:"binary,omitempty"`
+}
+
+// Recorder is the http.RoundTripper, which records the API responses into a
+// cassette file on the first run and replays them on the later runs, see
+// CassetteMode. It can be used as the Transport of the Client.HttpCli.
+// The same requests recorded multiple times are replayed in order, and the
+// last one is repeated thereafter.
+// If Transport is not provided, http.DefaultTransport is used for recording
+// If IgnoreParams is not provided, the DefaultAPIKeyName query parameter is
+// neither recorded nor matched, the request headers are never recorded
+type Recorder struct {
+	Transport    http.RoundTripper
+	IgnoreParams []string
+
+	path      string
+	recording bool
+
+	mu           sync.Mutex
+	interactions []Interaction
+	replayed     map[string]int
+}
+
+// NewRecorder returns the Recorder of the cassette file at path
+func NewRecorder(path string, mode CassetteMode) (*Recorder, error) {
+	r := &Recorder{path: path, replayed: map[string]int{}}
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case mode == CassetteRecord:
+		r.recording = true
+	case os.IsNotExist(err) && mode == CassetteAuto:
+		r.recording = true
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, &r.interactions); err != nil {
+			return nil, errors.New("freegeoiptest: invalid cassette " + path + ": " + err.Error())
+		}
+	}
+	return r, nil
+}
+
+// Recording reports whether the Recorder is recording, or replaying
+func (r *Recorder) Recording() bool {
+	return r.recording
+}
+
+// Client returns the http.Client using the Recorder
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// RoundTrip implements the http.RoundTripper
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	query := r.query(req)
+	if !r.recording {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return r.replay(req, query)
+	}
+
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	in := Interaction{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Query:  query,
+		Status: res.StatusCode,
+		Header: res.Header,
+		Body:   string(body),
+	}
+	if !utf8.Valid(body) {
+		in.Body, in.Binary = base64.StdEncoding.EncodeToString(body), true
+	}
+
+	r.mu.Lock()
+	r.interactions = append(r.interactions, in)
+	err = r.save()
+	r.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return response(req, in, body), nil
+}
+
+// replay returns the recorded response of the request
+func (r *Recorder) replay(req *http.Request, query string) (*http.Response, error) {
+	key := req.Method + " " + req.URL.Path + "?" + query
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var matched []int
+	for i, in := range r.interactions {
+		if in.Method == req.Method && in.Path == req.URL.Path && in.Query == query {
+			matched = append(matched, i)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, ErrNotRecorded
+	}
+	n := r.replayed[key]
+	if n >= len(matched) {
+		n = len(matched) - 1
+	}
+	r.replayed[key] = n + 1
+	in := r.interactions[matched[n]]
+
+	body := []byte(in.Body)
+	if in.Binary {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(in.Body); err != nil {
+			return nil, err
+		}
+	}
+	return response(req, in, body), nil
+}
+
+// query returns the encoded query of the request, without the IgnoreParams
+func (r *Recorder) query(req *http.Request) string {
+	q := req.URL.Query()
+	ignore := r.IgnoreParams
+	if ignore == nil {
+		ignore = []string{freeGeoIP.DefaultAPIKeyName}
+	}
+	for _, name := range ignore {
+		q.Del(name)
+	}
+	return q.Encode()
+}
+
+// save writes the cassette atomically, so that a failed run never leaves a
+// partial cassette
+func (r *Recorder) save() error {
+	data, err := json.MarshalIndent(r.interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(r.path), filepath.Base(r.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Close()
+	} else {
+		_ = tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), r.path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// response returns the http.Response of the interaction
+func response(req *http.Request, in Interaction, body []byte) *http.Response {
+	header := http.Header{}
+	for k, v := range in.Header {
+		header[k] = append([]string(nil), v...)
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(in.Status) + " " + http.StatusText(in.Status),
+		StatusCode:    in.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freegeoiptest_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Shivam010/go-freeGeoIP"
+	"github.com/Shivam010/go-freeGeoIP/freegeoiptest"
+)
+
+func TestRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freegeoiptest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cassette.json")
+	key := &freeGeoIP.APIKey{Key: "0123456789abcdef"}
+	ctx := context.Background()
+
+	// first run records
+	srv := freegeoiptest.NewServer(map[string]*freeGeoIP.Info{"8.8.8.8": dnsInfo()})
+	srv.Limit = 10
+	rec, err := freegeoiptest.NewRecorder(path, freegeoiptest.CassetteAuto)
+	if err != nil || !rec.Recording() {
+		t.Fatalf("NewRecorder() got = %v, %v, want recording", rec.Recording(), err)
+	}
+	var recorded []freeGeoIP.Response
+	for _, format := range []freeGeoIP.Format{freeGeoIP.FormatJSON, freeGeoIP.FormatJSON, freeGeoIP.FormatXML} {
+		cli := &freeGeoIP.Client{BaseURL: srv.URL, HttpCli: rec.Client(), Format: format, APIKey: key}
+		recorded = append(recorded, cli.GetGeoInfoFromString(ctx, "8.8.8.8"))
+	}
+	cli := &freeGeoIP.Client{BaseURL: srv.URL, HttpCli: rec.Client(), APIKey: key}
+	if res := cli.GetGeoInfoFromString(ctx, "1.1.1.1"); res.Error != freeGeoIP.ErrNoResponse {
+		t.Fatalf("GetGeoInfoFromString() error = %v, want %v", res.Error, freeGeoIP.ErrNoResponse)
+	}
+	srv.Close()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v, want no error", err)
+	}
+	if strings.Contains(string(data), key.Key) {
+		t.Fatalf("cassette must not contain the api key: %s", data)
+	}
+
+	// later runs replay, without the server
+	rec, err = freegeoiptest.NewRecorder(path, freegeoiptest.CassetteAuto)
+	if err != nil || rec.Recording() {
+		t.Fatalf("NewRecorder() got = %v, %v, want replaying", rec.Recording(), err)
+	}
+	for i, format := range []freeGeoIP.Format{freeGeoIP.FormatJSON, freeGeoIP.FormatJSON, freeGeoIP.FormatXML} {
+		cli := &freeGeoIP.Client{BaseURL: srv.URL, HttpCli: rec.Client(), Format: format, APIKey: key}
+		res := cli.GetGeoInfoFromString(ctx, "8.8.8.8")
+		want := recorded[i]
+		if res.Error != nil || res.Info.City != want.Info.City || res.Meta.Remaining != want.Meta.Remaining {
+			t.Fatalf("GetGeoInfoFromString() got = %+v, %+v, want %+v, %+v", res, res.Meta, want, want.Meta)
+		}
+	}
+	// the last recorded response repeats
+	cli = &freeGeoIP.Client{BaseURL: srv.URL, HttpCli: rec.Client()}
+	if res := cli.GetGeoInfoFromString(ctx, "8.8.8.8"); res.Error != nil || res.Meta.Remaining != 8 {
+		t.Fatalf("GetGeoInfoFromString() got = %+v, %+v", res, res.Meta)
+	}
+	if res := cli.GetGeoInfoFromString(ctx, "1.1.1.1"); res.Error != freeGeoIP.ErrNoResponse {
+		t.Fatalf("GetGeoInfoFromString() error = %v, want %v", res.Error, freeGeoIP.ErrNoResponse)
+	}
+	if res := cli.GetGeoInfoFromString(ctx, "9.9.9.9"); res.Error == nil {
+		t.Fatalf("GetGeoInfoFromString() of not recorded request error = nil, want error")
+	}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/csv/8.8.8.8", nil)
+	if _, err := rec.RoundTrip(req); err != freegeoiptest.ErrNotRecorded {
+		t.Fatalf("RoundTrip() error = %v, want %v", err, freegeoiptest.ErrNotRecorded)
+	}
+}
+
+func TestRecorderReplayMissingCassette(t *testing.T) {
+	if _, err := freegeoiptest.NewRecorder(filepath.Join(os.TempDir(), "missing-cassette.json"),
+		freegeoiptest.CassetteReplay); err == nil {
+		t.Fatalf("NewRecorder() error = nil, want error")
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package freegeoiptest provides an in-process fake of the freegeoip API, for
+// testing the freeGeoIP.Client without the network and without any quota.
+//
+//	srv := freegeoiptest.NewServer(map[string]*freeGeoIP.Info{"8.8.8.8": info})
+//	defer srv.Close()
+//	cli := &freeGeoIP.Client{BaseURL: srv.URL}
+package freegeoiptest
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP"
+)
+
+const (
+	// DefaultLimit is the number of requests allowed in a Window, when the
+	// Limit is not provided
+	DefaultLimit = 15000
+	// DefaultWindow is the duration of the quota window, when the Window is
+	// not provided
+	DefaultWindow = time.Hour
+)
+
+// Server is the fake freegeoip API, serving the `/{format}/{query}` requests
+// in the json, csv and xml formats from a table of the queries to Info.
+// Every request counts down the quota, reported in the x-ratelimit-* headers,
+// and once it is exhausted 403 is responded until the Window resets. The
+// queries not found in the table are responded with 404, the empty query is
+// the ip of the caller.
+// If Limit and Window are not provided, DefaultLimit and DefaultWindow are
+// used
+// If APIKey is provided, the requests without it, as the "apikey" query
+// parameter or header, are responded with 401
+// If Latency is provided, every response is delayed by it
+// If Fail is provided, it is called for every request and its non zero status
+// code is responded instead, see FailFirst
+// The fields should be set before making the requests.
+type Server struct {
+	*httptest.Server
+
+	Limit   int
+	Window  time.Duration
+	APIKey  string
+	Latency time.Duration
+	Fail    func(r *http.Request) int
+
+	mu        sync.Mutex
+	table     map[string]*freeGeoIP.Info
+	remaining int
+	resetAt   time.Time
+	requests  int
+}
+
+// NewServer starts and returns a new Server answering from the table, the
+// keys of the table are the ips or the hostnames. The caller should call
+// Close when finished, to shut it down.
+func NewServer(table map[string]*freeGeoIP.Info) *Server {
+	s := &Server{table: map[string]*freeGeoIP.Info{}}
+	for query, info := range table {
+		s.Set(query, info)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Set sets the Info of the query, an ip or a hostname
+func (s *Server) Set(query string, info *freeGeoIP.Info) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tmp := *info
+	s.table[key(query)] = &tmp
+}
+
+// Requests returns the number of the requests served so far
+func (s *Server) Requests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+// Remaining returns the remaining quota of the current window
+func (s *Server) Remaining() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.roll(time.Now())
+	return s.remaining
+}
+
+// FailFirst returns the Fail function, which fails the first n requests with
+// the status code
+func FailFirst(n int, status int) func(r *http.Request) int {
+	var count int32
+	return func(*http.Request) int {
+		if atomic.AddInt32(&count, 1) <= int32(n) {
+			return status
+		}
+		return 0
+	}
+}
+
+// key returns the table key of the query
+func key(query string) string {
+	if ip := freeGeoIP.ParseIP(query); len(ip) != 0 {
+		return ip.String()
+	}
+	return strings.TrimSuffix(strings.ToLower(query), ".")
+}
+
+// roll starts the new quota window, if the current one has passed
+func (s *Server) roll(now time.Time) {
+	if !s.resetAt.IsZero() && now.Before(s.resetAt) {
+		return
+	}
+	s.remaining = s.limit()
+	window := s.Window
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	s.resetAt = now.Add(window)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if s.Latency > 0 {
+		select {
+		case <-time.After(s.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	s.roll(now)
+	s.requests++
+	allowed := s.remaining > 0
+	if allowed {
+		s.remaining--
+	}
+	limit, remaining, resetIn := s.limit(), s.remaining, s.resetAt.Sub(now)
+	s.mu.Unlock()
+
+	h := w.Header()
+	h.Set("x-ratelimit-limit", strconv.Itoa(limit))
+	h.Set("x-ratelimit-remaining", strconv.Itoa(remaining))
+	h.Set("x-ratelimit-reset", strconv.Itoa(int((resetIn+time.Second-1)/time.Second)))
+
+	if s.Fail != nil {
+		if status := s.Fail(r); status != 0 {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+	}
+	if !allowed {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if s.APIKey != "" && r.URL.Query().Get("apikey") != s.APIKey && r.Header.Get("apikey") != s.APIKey {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	format, query := parts[0], ""
+	if len(parts) == 2 {
+		query = parts[1]
+	}
+	if query == "" {
+		query, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}
+	s.mu.Lock()
+	info, ok := s.table[key(query)]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	write(w, freeGeoIP.Format(format), info)
+}
+
+// limit returns the quota limit of a window
+func (s *Server) limit() int {
+	if s.Limit <= 0 {
+		return DefaultLimit
+	}
+	return s.Limit
+}
+
+// write writes the info in the format
+func write(w http.ResponseWriter, format freeGeoIP.Format, info *freeGeoIP.Info) {
+	tmp := *info
+	switch format {
+	case freeGeoIP.FormatJSON:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(&tmp)
+	case freeGeoIP.FormatXML:
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write([]byte(xml.Header))
+		_ = xml.NewEncoder(w).EncodeElement(&tmp, xml.StartElement{Name: xml.Name{Local: "Response"}})
+	case freeGeoIP.FormatCSV:
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{
+			tmp.IP.String(), tmp.CountryCode, tmp.CountryName, tmp.RegionCode, tmp.RegionName,
+			tmp.City, tmp.ZipCode, tmp.TimeZone.String(), float(tmp.Latitude), float(tmp.Longitude),
+			float(tmp.MetroCode),
+		})
+		cw.Flush()
+	default:
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	}
+}
+
+func float(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,141 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freegeoiptest_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP"
+	"github.com/Shivam010/go-freeGeoIP/freegeoiptest"
+)
+
+func dnsInfo() *freeGeoIP.Info {
+	zone, _ := time.LoadLocation("America/Chicago")
+	return &freeGeoIP.Info{
+		IP:          freeGeoIP.ParseIP("8.8.8.8"),
+		CountryCode: "US",
+		CountryName: "United States",
+		City:        "Chicago, IL",
+		TimeZone:    freeGeoIP.LocationF(zone),
+		Latitude:    37.751,
+		Longitude:   -97.822,
+	}
+}
+
+func TestServerFormats(t *testing.T) {
+	srv := freegeoiptest.NewServer(map[string]*freeGeoIP.Info{"8.8.8.8": dnsInfo()})
+	defer srv.Close()
+
+	for _, format := range []freeGeoIP.Format{freeGeoIP.FormatJSON, freeGeoIP.FormatCSV, freeGeoIP.FormatXML} {
+		t.Run(string(format), func(t *testing.T) {
+			cli := &freeGeoIP.Client{BaseURL: srv.URL, Format: format}
+			res := cli.GetGeoInfoFromString(context.Background(), "8.8.8.8")
+			if res.Error != nil {
+				t.Fatalf("GetGeoInfoFromString() error = %v, want no error", res.Error)
+			}
+			want := dnsInfo()
+			got := res.Info
+			if got.IP.String() != want.IP.String() || got.City != want.City || got.CountryName != want.CountryName ||
+				got.TimeZone.String() != want.TimeZone.String() || got.Latitude != want.Latitude ||
+				got.Longitude != want.Longitude {
+				t.Fatalf("GetGeoInfoFromString() got = %+v, want %+v", got, want)
+			}
+		})
+	}
+
+	cli := &freeGeoIP.Client{BaseURL: srv.URL}
+	if res := cli.GetGeoInfoFromString(context.Background(), "1.1.1.1"); res.Error != freeGeoIP.ErrNoResponse {
+		t.Fatalf("GetGeoInfoFromString() error = %v, want %v", res.Error, freeGeoIP.ErrNoResponse)
+	}
+	srv.Set("1.1.1.1", &freeGeoIP.Info{IP: freeGeoIP.ParseIP("1.1.1.1"), CountryCode: "AU"})
+	if res := cli.GetGeoInfoFromString(context.Background(), "1.1.1.1"); res.Error != nil || res.Info.CountryCode != "AU" {
+		t.Fatalf("GetGeoInfoFromString() got = %+v", res)
+	}
+}
+
+func TestServerQuota(t *testing.T) {
+	srv := freegeoiptest.NewServer(map[string]*freeGeoIP.Info{"8.8.8.8": dnsInfo()})
+	srv.Limit = 3
+	srv.Window = time.Minute
+	defer srv.Close()
+
+	cli := &freeGeoIP.Client{BaseURL: srv.URL}
+	ctx := context.Background()
+	for i := 2; i >= 0; i-- {
+		res := cli.GetGeoInfoFromString(ctx, "8.8.8.8")
+		if res.Error != nil || res.Meta.Limit != 3 || res.Meta.Remaining != int64(i) {
+			t.Fatalf("GetGeoInfoFromString() got = %+v, %+v, want remaining %v", res, res.Meta, i)
+		}
+		if res.Meta.ResetIn != time.Minute {
+			t.Fatalf("GetGeoInfoFromString() reset in got = %v, want %v", res.Meta.ResetIn, time.Minute)
+		}
+	}
+	if res := cli.GetGeoInfoFromString(ctx, "8.8.8.8"); res.Error != freeGeoIP.ErrLimitReached {
+		t.Fatalf("GetGeoInfoFromString() error = %v, want %v", res.Error, freeGeoIP.ErrLimitReached)
+	}
+	if srv.Requests() != 4 || srv.Remaining() != 0 {
+		t.Fatalf("Requests() got = %v, Remaining() got = %v", srv.Requests(), srv.Remaining())
+	}
+}
+
+func TestServerFailures(t *testing.T) {
+	srv := freegeoiptest.NewServer(map[string]*freeGeoIP.Info{"8.8.8.8": dnsInfo()})
+	srv.Fail = freegeoiptest.FailFirst(2, http.StatusServiceUnavailable)
+	srv.Latency = 10 * time.Millisecond
+	defer srv.Close()
+
+	cli := &freeGeoIP.Client{BaseURL: srv.URL}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if res := cli.GetGeoInfoFromString(ctx, "8.8.8.8"); res.Error != freeGeoIP.ErrInternal {
+			t.Fatalf("GetGeoInfoFromString() error = %v, want %v", res.Error, freeGeoIP.ErrInternal)
+		}
+	}
+	start := time.Now()
+	if res := cli.GetGeoInfoFromString(ctx, "8.8.8.8"); res.Error != nil {
+		t.Fatalf("GetGeoInfoFromString() error = %v, want no error", res.Error)
+	}
+	if elapsed := time.Since(start); elapsed < srv.Latency {
+		t.Fatalf("GetGeoInfoFromString() took %v, want at least %v", elapsed, srv.Latency)
+	}
+
+	// the latency is cut short by the context
+	ctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	if res := cli.GetGeoInfoFromString(ctx, "8.8.8.8"); res.Error == nil {
+		t.Fatalf("GetGeoInfoFromString() error = nil, want error")
+	}
+}
+
+func TestServerAPIKey(t *testing.T) {
+	srv := freegeoiptest.NewServer(map[string]*freeGeoIP.Info{"8.8.8.8": dnsInfo()})
+	srv.APIKey = "0123456789abcdef"
+	defer srv.Close()
+
+	ctx := context.Background()
+	cli := &freeGeoIP.Client{BaseURL: srv.URL}
+	if res := cli.GetGeoInfoFromString(ctx, "8.8.8.8"); res.Error != freeGeoIP.ErrInvalidKey {
+		t.Fatalf("GetGeoInfoFromString() error = %v, want %v", res.Error, freeGeoIP.ErrInvalidKey)
+	}
+	for _, in := range []freeGeoIP.KeyPlacement{freeGeoIP.KeyInQuery, freeGeoIP.KeyInHeader} {
+		cli = &freeGeoIP.Client{BaseURL: srv.URL, APIKey: &freeGeoIP.APIKey{Key: srv.APIKey, In: in}}
+		if res := cli.GetGeoInfoFromString(ctx, "8.8.8.8"); res.Error != nil {
+			t.Fatalf("GetGeoInfoFromString() error = %v, want no error", res.Error)
+		}
+	}
+}
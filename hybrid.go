@@ -0,0 +1,108 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP
+
+import (
+	"context"
+	"time"
+)
+
+// Freshness is an optional interface for the local Providers, which know when
+// their data was built, like the offline databases
+type Freshness interface {
+	BuildTime() time.Time
+}
+
+// HybridProvider is the Provider that answers from the Local database first
+// and calls the Remote API only when the Local has no record for the ip, has
+// only the country level precision, or its data is older than MaxAge.
+// If Remote is not provided, the freegeoip API is used with the configuration
+// of the Client, i.e. its HttpCli, BaseURL, APIKey, KeyPool, Retry, Limiter,
+// etc., or with the defaults of the HTTPProvider, if used without a Client.
+// The Limiter is applied to the Remote calls only, so the Local answers are
+// never failed for an exhausted quota.
+// If MaxAge is not provided, or the Local does not implement Freshness, the
+// Local data is never considered stale.
+// If Precise is not provided, the Local information with a City is precise.
+// If the Remote fails, the Local information is returned, even if imprecise
+// or stale, with the MetaInfo of the Remote.
+// The Client caches the fresh and precise Local information, and the Remote
+// one, so the same ip is not looked up from the Remote twice. The Local
+// information returned for a failed Remote is not cached, so the Remote is
+// asked again on the next lookup of the ip.
+type HybridProvider struct {
+	Local   Provider
+	Remote  Provider
+	MaxAge  time.Duration
+	Precise func(info *Info) bool
+}
+
+// Lookup looks up the ip locally first, see HybridProvider
+func (h *HybridProvider) Lookup(ctx context.Context, ip IP) (*Info, *MetaInfo, error) {
+	var local *Info
+	if len(ip) != 0 {
+		info, _, err := h.Local.Lookup(ctx, ip)
+		if err == nil && info != nil {
+			if h.fresh() && h.precise(info) {
+				return info, nil, nil
+			}
+			local = info
+		}
+	}
+
+	info, meta, err := h.remote().Lookup(ctx, ip)
+	if err != nil && local != nil && ctx.Err() == nil {
+		return local, uncached(meta), nil
+	}
+	return info, meta, err
+}
+
+// uncached returns a copy of meta, marked so that the Client does not cache
+// the information it is returned with
+func uncached(meta *MetaInfo) *MetaInfo {
+	m := &MetaInfo{}
+	if meta != nil {
+		*m = *meta
+	}
+	m.uncached = true
+	return m
+}
+
+// fresh reports whether the Local data is not older than the MaxAge
+func (h *HybridProvider) fresh() bool {
+	f, ok := h.Local.(Freshness)
+	if !ok || h.MaxAge <= 0 {
+		return true
+	}
+	built := f.BuildTime()
+	return built.IsZero() || time.Since(built) <= h.MaxAge
+}
+
+// precise reports whether the Local information is precise enough
+func (h *HybridProvider) precise(info *Info) bool {
+	if h.Precise != nil {
+		return h.Precise(info)
+	}
+	return info.City != ""
+}
+
+// remote returns the Remote provider, or the default HTTPProvider, the Client
+// sets the Remote with its configuration, see Client.provider
+func (h *HybridProvider) remote() Provider {
+	if h.Remote != nil {
+		return h.Remote
+	}
+	return &HTTPProvider{}
+}
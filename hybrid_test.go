@@ -0,0 +1,205 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP"
+)
+
+// datedProvider is a tableProvider with the Freshness
+type datedProvider struct {
+	*tableProvider
+	built time.Time
+}
+
+func (p datedProvider) BuildTime() time.Time {
+	return p.built
+}
+
+func TestHybridProvider(t *testing.T) {
+	countryOnly := &freeGeoIP.Info{IP: freeGeoIP.ParseIP(dnsIP), CountryCode: "US", CountryName: "United States"}
+	remoteDNS := &freeGeoIP.Info{IP: freeGeoIP.ParseIP(dnsIP), CountryCode: "US", City: "Mountain View"}
+
+	ctx := context.Background()
+	local := &tableProvider{table: map[string]*freeGeoIP.Info{
+		responseIP: response(),
+		dnsIP:      countryOnly,
+	}}
+	remote := &tableProvider{
+		table: map[string]*freeGeoIP.Info{dnsIP: remoteDNS, broadcastIP: broadcastResponse()},
+		meta:  &freeGeoIP.MetaInfo{Limit: 100, Remaining: 99},
+	}
+	cli := &freeGeoIP.Client{
+		Cache:    freeGeoIP.DefaultCache(),
+		Provider: &freeGeoIP.HybridProvider{Local: local, Remote: remote},
+	}
+
+	// precise local record
+	res := cli.GetGeoInfo(ctx, freeGeoIP.ParseIP(responseIP))
+	if res.Error != nil || compare(t, res.Info, response()) || remote.Calls() != 0 {
+		t.Fatalf("GetGeoInfo() got = %+v, remote calls = %v", res, remote.Calls())
+	}
+
+	// country level local record, cached after the remote call
+	for i := 0; i < 2; i++ {
+		res = cli.GetGeoInfo(ctx, freeGeoIP.ParseIP(dnsIP))
+		if res.Error != nil || res.Info.City != "Mountain View" || remote.Calls() != 1 {
+			t.Fatalf("GetGeoInfo() got = %+v, remote calls = %v", res, remote.Calls())
+		}
+	}
+
+	// no local record
+	res = cli.GetGeoInfo(ctx, freeGeoIP.ParseIP(broadcastIP))
+	if res.Error != nil || compare(t, res.Info, broadcastResponse()) || remote.Calls() != 2 {
+		t.Fatalf("GetGeoInfo() got = %+v, remote calls = %v", res, remote.Calls())
+	}
+	res = cli.GetGeoInfo(ctx, freeGeoIP.ParseIP("1.1.1.1"))
+	if res.Error != freeGeoIP.ErrNoResponse || remote.Calls() != 3 {
+		t.Fatalf("GetGeoInfo() got = %+v, remote calls = %v", res, remote.Calls())
+	}
+}
+
+func TestHybridProviderFreshness(t *testing.T) {
+	ctx := context.Background()
+	remoteInfo := response()
+	remoteInfo.City = "Belagavi"
+	remote := &tableProvider{table: map[string]*freeGeoIP.Info{responseIP: remoteInfo}}
+
+	tests := []struct {
+		name   string
+		built  time.Time
+		maxAge time.Duration
+		city   string
+	}{
+		{"fresh", time.Now().Add(-time.Hour), 24 * time.Hour, "Belgaum"},
+		{"stale", time.Now().Add(-48 * time.Hour), 24 * time.Hour, "Belagavi"},
+		{"no max age", time.Now().Add(-48 * time.Hour), 0, "Belgaum"},
+		{"unknown build time", time.Time{}, 24 * time.Hour, "Belgaum"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local := datedProvider{
+				tableProvider: &tableProvider{table: map[string]*freeGeoIP.Info{responseIP: response()}},
+				built:         tt.built,
+			}
+			h := &freeGeoIP.HybridProvider{Local: local, Remote: remote, MaxAge: tt.maxAge}
+			info, _, err := h.Lookup(ctx, freeGeoIP.ParseIP(responseIP))
+			if err != nil || info.City != tt.city {
+				t.Fatalf("Lookup() got = %+v, %v, want city %v", info, err, tt.city)
+			}
+		})
+	}
+}
+
+func TestHybridProviderRemoteFailure(t *testing.T) {
+	ctx := context.Background()
+	countryOnly := &freeGeoIP.Info{IP: freeGeoIP.ParseIP(dnsIP), CountryCode: "US"}
+	local := &tableProvider{table: map[string]*freeGeoIP.Info{dnsIP: countryOnly}}
+	remote := &tableProvider{
+		err:  freeGeoIP.ErrLimitReached,
+		meta: &freeGeoIP.MetaInfo{Limit: 100, ResetAt: time.Now().Add(time.Hour)},
+	}
+	h := &freeGeoIP.HybridProvider{Local: local, Remote: remote}
+
+	// the imprecise local record is better than nothing
+	info, meta, err := h.Lookup(ctx, freeGeoIP.ParseIP(dnsIP))
+	if err != nil || info.CountryCode != "US" || meta == nil || meta.Limit != 100 {
+		t.Fatalf("Lookup() got = %+v, %+v, %v", info, meta, err)
+	}
+	if _, _, err = h.Lookup(ctx, freeGeoIP.ParseIP(responseIP)); err != freeGeoIP.ErrLimitReached {
+		t.Fatalf("Lookup() error = %v, want %v", err, freeGeoIP.ErrLimitReached)
+	}
+
+	// the empty ip is always looked up remotely
+	remote.err = errors.New("http: connection refused")
+	if _, _, err = h.Lookup(ctx, nil); err == nil || local.Calls() != 2 {
+		t.Fatalf("Lookup() error = %v, local calls = %v", err, local.Calls())
+	}
+}
+
+func TestHybridProviderRemoteRecovery(t *testing.T) {
+	ctx := context.Background()
+	countryOnly := &freeGeoIP.Info{IP: freeGeoIP.ParseIP(dnsIP), CountryCode: "US"}
+	remoteDNS := &freeGeoIP.Info{IP: freeGeoIP.ParseIP(dnsIP), CountryCode: "US", City: "Mountain View"}
+	local := &tableProvider{table: map[string]*freeGeoIP.Info{dnsIP: countryOnly}}
+	remote := &tableProvider{
+		table: map[string]*freeGeoIP.Info{dnsIP: remoteDNS},
+		err:   errors.New("http: connection refused"),
+	}
+	cli := &freeGeoIP.Client{
+		Cache:    freeGeoIP.DefaultCache(),
+		Provider: &freeGeoIP.HybridProvider{Local: local, Remote: remote},
+	}
+
+	// the imprecise local record is served, but not cached
+	res := cli.GetGeoInfo(ctx, freeGeoIP.ParseIP(dnsIP))
+	if res.Error != nil || res.Cached || res.Info.City != "" || res.Info.CountryCode != "US" {
+		t.Fatalf("GetGeoInfo() got = %+v", res)
+	}
+
+	// the recovered remote is asked again, and its answer is cached
+	remote.mu.Lock()
+	remote.err = nil
+	remote.mu.Unlock()
+	for i := 0; i < 2; i++ {
+		res = cli.GetGeoInfo(ctx, freeGeoIP.ParseIP(dnsIP))
+		if res.Error != nil || res.Cached != (i == 1) || res.Info.City != "Mountain View" || remote.Calls() != 2 {
+			t.Fatalf("GetGeoInfo() #%v got = %+v, remote calls = %v", i, res, remote.Calls())
+		}
+	}
+}
+
+func TestHybridProviderClientRemote(t *testing.T) {
+	srv := fakeAPI()
+	defer srv.Close()
+	srv.APIKey = "0123456789abcdef"
+	srv.Limit = 1
+
+	local := &tableProvider{table: map[string]*freeGeoIP.Info{dnsIP: response()}}
+	hybrid := &freeGeoIP.HybridProvider{Local: local}
+	cli := &freeGeoIP.Client{
+		BaseURL:  srv.URL,
+		APIKey:   &freeGeoIP.APIKey{Key: srv.APIKey},
+		Limiter:  freeGeoIP.NewRateLimiter(freeGeoIP.LimitFailFast),
+		Provider: hybrid,
+	}
+	ctx := context.Background()
+
+	// the remote is the freegeoip API, as configured in the Client
+	res := cli.GetGeoInfo(ctx, freeGeoIP.ParseIP(responseIP))
+	if res.Error != nil || compare(t, res.Info, response()) || srv.Requests() != 1 {
+		t.Fatalf("GetGeoInfo() got = %+v, %v requests", res, srv.Requests())
+	}
+	if hybrid.Remote != nil {
+		t.Fatalf("HybridProvider.Remote got = %v, must not be modified", hybrid.Remote)
+	}
+
+	// the exhausted quota fails the remote lookups only, without an API call
+	res = cli.GetGeoInfo(ctx, freeGeoIP.ParseIP(broadcastIP))
+	if res.Error != freeGeoIP.ErrLimitReached {
+		t.Fatalf("GetGeoInfo() error = %v, want %v", res.Error, freeGeoIP.ErrLimitReached)
+	}
+	if srv.Requests() != 1 {
+		t.Fatalf("API requests got = %v, want %v", srv.Requests(), 1)
+	}
+	if res = cli.GetGeoInfo(ctx, freeGeoIP.ParseIP(dnsIP)); res.Error != nil {
+		t.Fatalf("GetGeoInfo() of local ip error = %v, want no error", res.Error)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cacheutil holds the helpers shared by the cache implementations of
+// the module, the in-memory ones of the freeGeoIP package, the diskcache and
+// the rediscache, so that they resolve the expiry and key the entries alike.
+package cacheutil
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// Skip is the expiry duration, which explicitly denies the cache hit, same as
+// the freeGeoIP.SkipCache
+const Skip = -1 << 63
+
+// Expiry is the expiry of the entries of a cache, the fixed Expiry duration,
+// or as per the ExpiryFn if provided, where its zero duration falls back to
+// the Expiry. The ExpiryFn is the freeGeoIP.CacheExpiryFunction of the cache.
+type Expiry struct {
+	Expiry   time.Duration
+	ExpiryFn func(ctx context.Context, ip net.IP) time.Duration
+}
+
+// At returns the expiry time of the ip's entry, the zero time if it never
+// expires, and false if the ip must not be cached at all
+func (e Expiry) At(ctx context.Context, ip net.IP, now time.Time) (time.Time, bool) {
+	dur := e.Expiry
+	if e.ExpiryFn != nil {
+		if dur = e.ExpiryFn(ctx, ip); dur == 0 {
+			dur = e.Expiry
+		}
+	}
+	if dur == Skip {
+		return time.Time{}, false
+	}
+	if dur <= 0 {
+		return time.Time{}, true
+	}
+	return now.Add(dur), true
+}
+
+// Skip reports whether the cache hit is explicitly denied for the ip, the
+// ExpiryFn is only called if provided
+func (e Expiry) Skip(ctx context.Context, ip net.IP) bool {
+	if e.ExpiryFn == nil {
+		return e.Expiry == Skip
+	}
+	return e.ExpiryFn(ctx, ip) == Skip
+}
+
+// IPKey returns the 16 byte key of the ip, the IPv4 ones in their IPv4 mapped
+// IPv6 form, and false if the ip is not valid, like the empty ip of the
+// caller, which is to be keyed by its String instead
+func IPKey(ip net.IP) ([net.IPv6len]byte, bool) {
+	var key [net.IPv6len]byte
+	switch len(ip) {
+	case net.IPv4len:
+		key[10], key[11] = 0xff, 0xff
+		copy(key[12:], ip)
+	case net.IPv6len:
+		copy(key[:], ip)
+	default:
+		return key, false
+	}
+	return key, true
+}
+
+// HostKey returns the key of the hostname, prefixed so that it never collides
+// with the String keys of the ips
+func HostKey(host string) string {
+	return "host:" + host
+}
@@ -0,0 +1,441 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ipcsv loads the IP-range CSV databases, like DB-IP Lite or
+// IP2Location LITE, into a compact sorted range index, which can be used as an
+// offline freeGeoIP.Provider answering the lookups in O(log n).
+package ipcsv
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP"
+)
+
+// Field is a field of the csv rows, mapped into the freeGeoIP.Info
+type Field int
+
+const (
+	// Start is the first ip of the range, in the ip or the decimal notation
+	Start Field = iota
+	// End is the last ip of the range, in the ip or the decimal notation
+	End
+	CountryCode
+	CountryName
+	RegionCode
+	RegionName
+	City
+	ZipCode
+	// TimeZone is either the IANA name, or the UTC offset like "+05:30"
+	TimeZone
+	Latitude
+	Longitude
+	MetroCode
+)
+
+// Mapping maps the Fields to their zero based column positions, the Start and
+// the End are required and the rest of the fields are optional
+type Mapping map[Field]int
+
+var (
+	// DBIPCityLite is the Mapping of the DB-IP IP to City Lite csv
+	DBIPCityLite = Mapping{Start: 0, End: 1, CountryCode: 3, RegionName: 4, City: 5, Latitude: 6, Longitude: 7}
+	// DBIPCountryLite is the Mapping of the DB-IP IP to Country Lite csv
+	DBIPCountryLite = Mapping{Start: 0, End: 1, CountryCode: 2}
+	// IP2LocationLiteDB11 is the Mapping of the IP2Location LITE DB11 csv
+	IP2LocationLiteDB11 = Mapping{Start: 0, End: 1, CountryCode: 2, CountryName: 3, RegionName: 4, City: 5,
+		Latitude: 6, Longitude: 7, ZipCode: 8, TimeZone: 9}
+)
+
+// location is the deduplicated location information of the ranges
+type location struct {
+	countryCode, countryName string
+	regionCode, regionName   string
+	city, zipCode            string
+	timeZone                 *freeGeoIP.Location
+	latitude, longitude      float64
+	metroCode                float64
+}
+
+// uint128 is an ipv6 address
+type uint128 struct {
+	hi, lo uint64
+}
+
+func (a uint128) less(b uint128) bool {
+	return a.hi < b.hi || (a.hi == b.hi && a.lo < b.lo)
+}
+
+// DB is the sorted range index of an IP-range csv database. It is safe for
+// the concurrent use.
+type DB struct {
+	// ipv4 ranges
+	v4Start, v4End []uint32
+	v4Loc          []uint32
+	// ipv6 ranges
+	v6Start, v6End []uint128
+	v6Loc          []uint32
+
+	locations []location
+	built     time.Time
+}
+
+// Open loads the csv database file at path, its modification time is used as
+// the BuildTime of the DB
+func Open(path string, m Mapping) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	db, err := Load(f, m)
+	if err != nil {
+		return nil, err
+	}
+	if stat, err := f.Stat(); err == nil {
+		db.built = stat.ModTime()
+	}
+	return db, nil
+}
+
+// Load loads the csv database from r. The first row is skipped if its Start
+// is not an ip, i.e. a header row. The ranges must not overlap.
+func Load(r io.Reader, m Mapping) (*DB, error) {
+	if _, ok := m[Start]; !ok {
+		return nil, errors.New("ipcsv: mapping of Start is required")
+	}
+	if _, ok := m[End]; !ok {
+		return nil, errors.New("ipcsv: mapping of End is required")
+	}
+
+	cr := csv.NewReader(bufio.NewReaderSize(r, 1<<20))
+	cr.ReuseRecord = true
+	cr.FieldsPerRecord = -1
+	cr.Comment = '#'
+
+	db := &DB{}
+	l := &loader{db: db, mapping: m, index: map[location]uint32{}, zones: map[string]*freeGeoIP.Location{}, strs: map[string]string{}}
+	for row := 1; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.New("ipcsv: " + err.Error())
+		}
+		if row == 1 {
+			if _, _, err := parseIP(l.field(record, Start)); err != nil {
+				continue // header
+			}
+		}
+		if err := l.add(record); err != nil {
+			return nil, errors.New("ipcsv: row " + strconv.Itoa(row) + ": " + err.Error())
+		}
+	}
+	db.sort()
+	return db, nil
+}
+
+// loader builds the DB from the csv rows
+type loader struct {
+	db      *DB
+	mapping Mapping
+	index   map[location]uint32
+	zones   map[string]*freeGeoIP.Location
+	strs    map[string]string
+}
+
+// intern returns the shared copy of s, so that the repeated values, like the
+// country names, are stored only once
+func (l *loader) intern(s string) string {
+	if v, ok := l.strs[s]; ok {
+		return v
+	}
+	v := string([]byte(s))
+	l.strs[v] = v
+	return v
+}
+
+// field returns the value of the Field in the csv row, the unknown values
+// marked as "-" are returned empty
+func (l *loader) field(record []string, f Field) string {
+	i, ok := l.mapping[f]
+	if !ok || i < 0 || i >= len(record) {
+		return ""
+	}
+	if v := strings.TrimSpace(record[i]); v != "-" {
+		return v
+	}
+	return ""
+}
+
+// add adds the csv row to the DB
+func (l *loader) add(record []string) error {
+	col := func(f Field) string {
+		return l.field(record, f)
+	}
+	start, end, err := parseRange(col(Start), col(End))
+	if err != nil {
+		return err
+	}
+
+	loc := location{
+		countryCode: col(CountryCode),
+		countryName: col(CountryName),
+		regionCode:  col(RegionCode),
+		regionName:  col(RegionName),
+		city:        col(City),
+		zipCode:     col(ZipCode),
+	}
+	if zone := col(TimeZone); zone != "" {
+		if loc.timeZone, err = l.zone(zone); err != nil {
+			return err
+		}
+	}
+	if loc.latitude, err = parseFloat(col(Latitude)); err != nil {
+		return err
+	}
+	if loc.longitude, err = parseFloat(col(Longitude)); err != nil {
+		return err
+	}
+	if loc.metroCode, err = parseFloat(col(MetroCode)); err != nil {
+		return err
+	}
+
+	idx, ok := l.index[loc]
+	if !ok {
+		// the fields share the memory of the whole csv row, clone them so
+		// that the row can be released
+		loc.countryCode = l.intern(loc.countryCode)
+		loc.countryName = l.intern(loc.countryName)
+		loc.regionCode = l.intern(loc.regionCode)
+		loc.regionName = l.intern(loc.regionName)
+		loc.city = l.intern(loc.city)
+		loc.zipCode = l.intern(loc.zipCode)
+		idx = uint32(len(l.db.locations))
+		l.db.locations = append(l.db.locations, loc)
+		l.index[loc] = idx
+	}
+
+	db := l.db
+	if s4, e4 := start.To4(), end.To4(); s4 != nil {
+		db.v4Start = append(db.v4Start, v4(s4))
+		db.v4End = append(db.v4End, v4(e4))
+		db.v4Loc = append(db.v4Loc, idx)
+		return nil
+	}
+	db.v6Start = append(db.v6Start, v6(start))
+	db.v6End = append(db.v6End, v6(end))
+	db.v6Loc = append(db.v6Loc, idx)
+	return nil
+}
+
+// zone returns the Location of the time zone, loaded only once
+func (l *loader) zone(name string) (*freeGeoIP.Location, error) {
+	if zone, ok := l.zones[name]; ok {
+		return zone, nil
+	}
+	var zone *time.Location
+	if name[0] == '+' || name[0] == '-' {
+		parts := strings.SplitN(name[1:], ":", 2)
+		h, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		m := 0
+		if len(parts) == 2 {
+			if m, err = strconv.Atoi(parts[1]); err != nil {
+				return nil, err
+			}
+		}
+		offset := h*3600 + m*60
+		if name[0] == '-' {
+			offset = -offset
+		}
+		zone = time.FixedZone("UTC"+name, offset)
+	} else {
+		var err error
+		if zone, err = time.LoadLocation(name); err != nil {
+			return nil, err
+		}
+	}
+	l.zones[name] = freeGeoIP.LocationF(zone)
+	return l.zones[name], nil
+}
+
+// parseRange parses the start and the end ip of a range. The decimal ips are
+// IPv4 only if both of them fit in 32 bits, as the IPv6 datasets, like the
+// IP2Location's, also start from 0.
+func parseRange(s, e string) (net.IP, net.IP, error) {
+	start, small, err := parseIP(s)
+	if err != nil {
+		return nil, nil, err
+	}
+	end, endSmall, err := parseIP(e)
+	if err != nil {
+		return nil, nil, err
+	}
+	if small && endSmall {
+		start, end = toV4(start), toV4(end)
+	}
+	if (start.To4() == nil) != (end.To4() == nil) {
+		return nil, nil, errors.New("start and end ip versions differ")
+	}
+	return start, end, nil
+}
+
+// parseIP parses the ip in the ip or the decimal notation, the decimal ips are
+// parsed as IPv6, small reports whether the ip fits in 32 bits
+func parseIP(s string) (net.IP, bool, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip, ip.To4() != nil, nil
+	}
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Sign() < 0 || n.BitLen() > 128 {
+		return nil, false, errors.New("invalid ip " + strconv.Quote(s))
+	}
+	return net.IP(leftPad(n.Bytes(), net.IPv6len)), n.BitLen() <= 32, nil
+}
+
+// toV4 returns the IPv4 of the ip fitting in 32 bits
+func toV4(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.To16()
+	}
+	return net.IPv4(ip[12], ip[13], ip[14], ip[15])
+}
+
+// parseFloat parses the number, the empty value is parsed as zero
+func parseFloat(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func leftPad(b []byte, n int) []byte {
+	return append(make([]byte, n-len(b)), b...)
+}
+
+func v4(ip net.IP) uint32 {
+	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+}
+
+func v6(ip net.IP) uint128 {
+	ip = ip.To16()
+	var a uint128
+	for i := 0; i < 8; i++ {
+		a.hi = a.hi<<8 | uint64(ip[i])
+		a.lo = a.lo<<8 | uint64(ip[i+8])
+	}
+	return a
+}
+
+// sort sorts the ranges by their start, if not sorted already
+func (db *DB) sort() {
+	if !sort.IsSorted(v4Ranges{db}) {
+		sort.Sort(v4Ranges{db})
+	}
+	if !sort.IsSorted(v6Ranges{db}) {
+		sort.Sort(v6Ranges{db})
+	}
+}
+
+type v4Ranges struct{ db *DB }
+
+func (r v4Ranges) Len() int           { return len(r.db.v4Start) }
+func (r v4Ranges) Less(i, j int) bool { return r.db.v4Start[i] < r.db.v4Start[j] }
+func (r v4Ranges) Swap(i, j int) {
+	db := r.db
+	db.v4Start[i], db.v4Start[j] = db.v4Start[j], db.v4Start[i]
+	db.v4End[i], db.v4End[j] = db.v4End[j], db.v4End[i]
+	db.v4Loc[i], db.v4Loc[j] = db.v4Loc[j], db.v4Loc[i]
+}
+
+type v6Ranges struct{ db *DB }
+
+func (r v6Ranges) Len() int           { return len(r.db.v6Start) }
+func (r v6Ranges) Less(i, j int) bool { return r.db.v6Start[i].less(r.db.v6Start[j]) }
+func (r v6Ranges) Swap(i, j int) {
+	db := r.db
+	db.v6Start[i], db.v6Start[j] = db.v6Start[j], db.v6Start[i]
+	db.v6End[i], db.v6End[j] = db.v6End[j], db.v6End[i]
+	db.v6Loc[i], db.v6Loc[j] = db.v6Loc[j], db.v6Loc[i]
+}
+
+// Len returns the number of the ip ranges in the DB
+func (db *DB) Len() int {
+	return len(db.v4Start) + len(db.v6Start)
+}
+
+// BuildTime returns the modification time of the database file, or the zero
+// time if the DB was not loaded with Open
+func (db *DB) BuildTime() time.Time {
+	return db.built
+}
+
+// find returns the location of the ip, if found
+func (db *DB) find(ip net.IP) (*location, bool) {
+	if ip4 := ip.To4(); ip4 != nil {
+		x := v4(ip4)
+		i := sort.Search(len(db.v4Start), func(i int) bool { return db.v4Start[i] > x }) - 1
+		if i < 0 || x > db.v4End[i] {
+			return nil, false
+		}
+		return &db.locations[db.v4Loc[i]], true
+	}
+	if len(ip) != net.IPv6len {
+		return nil, false
+	}
+	x := v6(ip)
+	i := sort.Search(len(db.v6Start), func(i int) bool { return x.less(db.v6Start[i]) }) - 1
+	if i < 0 || db.v6End[i].less(x) {
+		return nil, false
+	}
+	return &db.locations[db.v6Loc[i]], true
+}
+
+// Lookup implements the freeGeoIP.Provider. The empty ip and the ips not
+// found in the database are responded with `freeGeoIP.ErrNoResponse`. No
+// MetaInfo is returned, as there is no quota for the offline lookups.
+func (db *DB) Lookup(_ context.Context, ip freeGeoIP.IP) (*freeGeoIP.Info, *freeGeoIP.MetaInfo, error) {
+	loc, ok := db.find(ip.Net())
+	if !ok {
+		return nil, nil, freeGeoIP.ErrNoResponse
+	}
+	return &freeGeoIP.Info{
+		IP:          ip,
+		CountryCode: loc.countryCode,
+		CountryName: loc.countryName,
+		RegionCode:  loc.regionCode,
+		RegionName:  loc.regionName,
+		City:        loc.city,
+		ZipCode:     loc.zipCode,
+		MetroCode:   loc.metroCode,
+		TimeZone:    loc.timeZone,
+		Latitude:    loc.latitude,
+		Longitude:   loc.longitude,
+	}, nil, nil
+}
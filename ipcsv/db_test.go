@@ -0,0 +1,240 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipcsv_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP"
+	"github.com/Shivam010/go-freeGeoIP/ipcsv"
+)
+
+// dbipCSV is a DB-IP City Lite like csv, unsorted and without header
+const dbipCSV = `8.8.8.0,8.8.8.255,NA,US,California,Mountain View,37.386,-122.0838
+1.0.0.0,1.0.0.255,OC,AU,Queensland,South Brisbane,-27.4748,153.017
+2401:4900::,2401:4900:ffff:ffff:ffff:ffff:ffff:ffff,AS,IN,Karnataka,Belgaum,15.8521,74.5045
+1.0.1.0,1.0.3.255,AS,CN,Fujian,Fuzhou,26.0614,119.306
+`
+
+// ip2locationCSV is an IP2Location LITE DB11 like csv, with decimal ips
+const ip2locationCSV = `"ip_from","ip_to","country_code","country_name","region_name","city_name","latitude","longitude","zip_code","time_zone"
+"0","16777215","-","-","-","-","0.000000","0.000000","-","-"
+"134744064","134744319","US","United States of America","California","Mountain View","37.405991","-122.078514","94043","-07:00"
+"281470698651648","281470698717183","AU","Australia","Queensland","Brisbane","-27.467580","153.027892","4000","+10:00"
+"47858880761016572824106532815765504000","47858880840244735338370870409309454335","IN","India","Karnataka","Belgaum","15.852100","74.504500","590006","+05:30"
+`
+
+// ip2locationV6CSV is an IP2Location LITE DB11 IPv6 like csv, which starts
+// with the IPv6 range below the ipv4 mapped ones
+const ip2locationV6CSV = `"0","281470681743359","-","-","-","-","0.000000","0.000000","-","-"
+"281470681743360","281470698520575","-","-","-","-","0.000000","0.000000","-","-"
+"281470698651648","281470698717183","AU","Australia","Queensland","Brisbane","-27.467580","153.027892","4000","+10:00"
+"47858880761016572824106532815765504000","47858880840244735338370870409309454335","IN","India","Karnataka","Belgaum","15.852100","74.504500","590006","+05:30"
+`
+
+func TestLoadDBIP(t *testing.T) {
+	db, err := ipcsv.Load(strings.NewReader(dbipCSV), ipcsv.DBIPCityLite)
+	if err != nil {
+		t.Fatalf("Load() error = %v, want no error", err)
+	}
+	if db.Len() != 4 {
+		t.Fatalf("Len() got = %v, want %v", db.Len(), 4)
+	}
+	if !db.BuildTime().IsZero() {
+		t.Fatalf("BuildTime() got = %v, want zero time", db.BuildTime())
+	}
+
+	ctx := context.Background()
+	tests := []struct {
+		ip, city string
+	}{
+		{"8.8.8.8", "Mountain View"},
+		{"8.8.8.0", "Mountain View"},
+		{"8.8.8.255", "Mountain View"},
+		{"1.0.2.7", "Fuzhou"},
+		{"1.0.0.0", "South Brisbane"},
+		{"2401:4900:16ff:f1ef:fff5:f63e:8a25:a38a", "Belgaum"},
+		{"::ffff:1.0.3.255", "Fuzhou"},
+	}
+	for _, tt := range tests {
+		info, meta, err := db.Lookup(ctx, freeGeoIP.ParseIP(tt.ip))
+		if err != nil || meta != nil {
+			t.Fatalf("Lookup(%v) got = %v, %v, want no error", tt.ip, meta, err)
+		}
+		if info.City != tt.city || info.IP.String() != freeGeoIP.ParseIP(tt.ip).String() {
+			t.Fatalf("Lookup(%v) got = %+v, want city %v", tt.ip, info, tt.city)
+		}
+	}
+
+	info, _, _ := db.Lookup(ctx, freeGeoIP.ParseIP("8.8.8.8"))
+	if info.CountryCode != "US" || info.RegionName != "California" || info.Latitude != 37.386 || info.Longitude != -122.0838 {
+		t.Fatalf("Lookup() info got = %+v", info)
+	}
+
+	for _, ip := range []string{"8.8.9.0", "0.0.0.0", "1.0.4.0", "255.255.255.255", "2401:4901::1", "::1"} {
+		if _, _, err := db.Lookup(ctx, freeGeoIP.ParseIP(ip)); err != freeGeoIP.ErrNoResponse {
+			t.Fatalf("Lookup(%v) error = %v, want %v", ip, err, freeGeoIP.ErrNoResponse)
+		}
+	}
+	if _, _, err := db.Lookup(ctx, nil); err != freeGeoIP.ErrNoResponse {
+		t.Fatalf("Lookup() error = %v, want %v", err, freeGeoIP.ErrNoResponse)
+	}
+}
+
+func TestLoadIP2Location(t *testing.T) {
+	db, err := ipcsv.Load(strings.NewReader(ip2locationCSV), ipcsv.IP2LocationLiteDB11)
+	if err != nil {
+		t.Fatalf("Load() error = %v, want no error", err)
+	}
+	if db.Len() != 4 {
+		t.Fatalf("Len() got = %v, want %v", db.Len(), 4)
+	}
+
+	ctx := context.Background()
+	info, _, err := db.Lookup(ctx, freeGeoIP.ParseIP("8.8.8.8"))
+	if err != nil || info.CountryName != "United States of America" || info.ZipCode != "94043" ||
+		info.TimeZone.String() != "UTC-07:00" {
+		t.Fatalf("Lookup() got = %+v, %v", info, err)
+	}
+	_, offset := time.Unix(0, 0).In(info.TimeZone.Time()).Zone()
+	if offset != -7*3600 {
+		t.Fatalf("Lookup() time zone offset got = %v, want %v", offset, -7*3600)
+	}
+
+	// ipv4 mapped ranges of the ipv6 database are the ipv4 ranges
+	info, _, err = db.Lookup(ctx, freeGeoIP.ParseIP("1.2.3.4"))
+	if err != nil || info.City != "Brisbane" || info.TimeZone.String() != "UTC+10:00" {
+		t.Fatalf("Lookup() got = %+v, %v", info, err)
+	}
+
+	info, _, err = db.Lookup(ctx, freeGeoIP.ParseIP("2401:4900:16ff:f1ef:fff5:f63e:8a25:a38a"))
+	if err != nil || info.City != "Belgaum" || info.TimeZone.String() != "UTC+05:30" {
+		t.Fatalf("Lookup() got = %+v, %v", info, err)
+	}
+
+	// the unknown rows are the empty information
+	info, _, err = db.Lookup(ctx, freeGeoIP.ParseIP("0.1.2.3"))
+	if err != nil || info.CountryCode != "" || info.City != "" || info.TimeZone != nil {
+		t.Fatalf("Lookup() got = %+v, %v", info, err)
+	}
+}
+
+func TestLoadIP2LocationV6(t *testing.T) {
+	db, err := ipcsv.Load(strings.NewReader(ip2locationV6CSV), ipcsv.IP2LocationLiteDB11)
+	if err != nil {
+		t.Fatalf("Load() error = %v, want no error", err)
+	}
+	if db.Len() != 4 {
+		t.Fatalf("Len() got = %v, want %v", db.Len(), 4)
+	}
+
+	ctx := context.Background()
+	tests := []struct {
+		ip, city string
+		err      error
+	}{
+		{"1.2.3.4", "Brisbane", nil},
+		{"::ffff:1.2.3.4", "Brisbane", nil},
+		{"2401:4900:16ff:f1ef:fff5:f63e:8a25:a38a", "Belgaum", nil},
+		{"0.0.0.1", "", nil},
+		{"::1", "", nil},
+		{"8.8.8.8", "", freeGeoIP.ErrNoResponse},
+	}
+	for _, tt := range tests {
+		info, _, err := db.Lookup(ctx, freeGeoIP.ParseIP(tt.ip))
+		if err != tt.err || (err == nil && info.City != tt.city) {
+			t.Fatalf("Lookup(%v) got = %+v, %v, want %v, %v", tt.ip, info, err, tt.city, tt.err)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+		m    ipcsv.Mapping
+	}{
+		{"no start", dbipCSV, ipcsv.Mapping{ipcsv.End: 1}},
+		{"no end", dbipCSV, ipcsv.Mapping{ipcsv.Start: 0}},
+		{"invalid ip", dbipCSV + "1.0.4.0,x,AS,CN,,,0,0\n", ipcsv.DBIPCityLite},
+		{"mixed versions", "1.0.0.0,::1,AS,CN,,,0,0\n", ipcsv.DBIPCityLite},
+		{"invalid number", dbipCSV + "1.0.4.0,1.0.4.1,AS,CN,,,x,0\n", ipcsv.DBIPCityLite},
+		{"invalid zone", ip2locationCSV + `"1","2","-","-","-","-","0","0","-","+x"` + "\n", ipcsv.IP2LocationLiteDB11},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ipcsv.Load(strings.NewReader(tt.csv), tt.m); err == nil {
+				t.Fatalf("Load() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestOpenAndClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipcsv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dbip-city-lite.csv")
+	if err := ioutil.WriteFile(path, []byte(dbipCSV), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := ipcsv.Open(path, ipcsv.DBIPCityLite)
+	if err != nil {
+		t.Fatalf("Open() error = %v, want no error", err)
+	}
+	stat, _ := os.Stat(path)
+	if !db.BuildTime().Equal(stat.ModTime()) {
+		t.Fatalf("BuildTime() got = %v, want %v", db.BuildTime(), stat.ModTime())
+	}
+
+	cli := &freeGeoIP.Client{Provider: db}
+	res := cli.GetGeoInfo(context.Background(), freeGeoIP.ParseIP("1.0.2.7"))
+	if res.Error != nil || res.Info.City != "Fuzhou" {
+		t.Fatalf("GetGeoInfo() got = %+v", res)
+	}
+	if _, err := ipcsv.Open(filepath.Join(dir, "missing.csv"), ipcsv.DBIPCityLite); err == nil {
+		t.Fatalf("Open() of missing file error = nil, want error")
+	}
+}
+
+func BenchmarkLookup(b *testing.B) {
+	var sb strings.Builder
+	for i := 0; i < 1<<16; i++ {
+		fmt.Fprintf(&sb, "%d.%d.0.0,%d.%d.255.255,EU,DE,Region %d,City %d,50.1,8.6\n", i>>8, i&0xff, i>>8, i&0xff, i%100, i%1000)
+	}
+	db, err := ipcsv.Load(strings.NewReader(sb.String()), ipcsv.DBIPCityLite)
+	if err != nil {
+		b.Fatal(err)
+	}
+	ip := freeGeoIP.ParseIP("123.45.67.89")
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := db.Lookup(ctx, ip); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
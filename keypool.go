@@ -0,0 +1,151 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// KeyQuota is the quota of an APIKey in the KeyPool
+type KeyQuota struct {
+	// Key is the Redacted form of the APIKey
+	Key string
+	// Known reports whether the quota was reported by the API yet
+	Known     bool
+	Limit     int64
+	Remaining int64
+	ResetAt   time.Time
+	// SkipUntil is the time till which the key is skipped, after the API
+	// responded with 403 or 401 for it
+	SkipUntil time.Time
+}
+
+// KeyPool is the pool of the APIKeys, rotated as per their quota reported in
+// the x-ratelimit-* headers. Every request uses the key with the most
+// remaining quota, the keys not used yet are preferred to learn their quota,
+// and the ties are broken in a round-robin. The keys which exhausted or
+// reached their limit are skipped until their quota resets, and the keys
+// rejected as invalid for the DefaultQuotaWindow.
+// It is safe for the concurrent use.
+type KeyPool struct {
+	Keys []*APIKey
+
+	mu     sync.Mutex
+	quotas map[int]*KeyQuota
+	// next is the key the ties are broken from, so that the concurrent first
+	// requests are spread over the keys with the unknown quota
+	next int
+}
+
+// NewKeyPool returns the KeyPool of the keys
+func NewKeyPool(keys ...*APIKey) *KeyPool {
+	return &KeyPool{Keys: keys}
+}
+
+// Quotas returns the snapshot of the quota of every key, in order
+func (k *KeyPool) Quotas() []KeyQuota {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := time.Now()
+	quotas := make([]KeyQuota, len(k.Keys))
+	for i := range k.Keys {
+		quotas[i] = *k.quota(i, now)
+	}
+	return quotas
+}
+
+// pick returns the key with the most remaining quota and its position, or
+// ErrLimitReached, with the earliest reset, if every key is skipped
+func (k *KeyPool) pick() (int, *APIKey, *MetaInfo, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := time.Now()
+	best, bestRemaining := -1, int64(-1)
+	var earliest time.Time
+	for j := range k.Keys {
+		i := (k.next + j) % len(k.Keys)
+		q := k.quota(i, now)
+		if until := q.SkipUntil; until.After(now) || (q.Known && q.Remaining <= 0) {
+			if !until.After(now) {
+				until = q.ResetAt
+			}
+			if earliest.IsZero() || until.Before(earliest) {
+				earliest = until
+			}
+			continue
+		}
+		remaining := q.Remaining
+		if !q.Known {
+			remaining = math.MaxInt64
+		}
+		if remaining > bestRemaining {
+			best, bestRemaining = i, remaining
+		}
+	}
+	if best < 0 {
+		return -1, nil, &MetaInfo{ResetIn: time.Until(earliest), ResetAt: earliest}, ErrLimitReached
+	}
+	// reserve the quota, until the API reports it
+	if q := k.quotas[best]; q.Known && q.Remaining > 0 {
+		q.Remaining--
+	}
+	k.next = best + 1
+	return best, k.Keys[best], nil, nil
+}
+
+// observe records the quota of the ith key from the API response
+func (k *KeyPool) observe(i int, meta *MetaInfo, err error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := time.Now()
+	q := k.quota(i, now)
+	if meta != nil && !meta.ResetAt.IsZero() {
+		q.Known, q.Limit, q.Remaining, q.ResetAt = true, meta.Limit, meta.Remaining, meta.ResetAt
+	}
+	if err != ErrLimitReached && err != ErrInvalidKey {
+		return
+	}
+	q.SkipUntil = now.Add(DefaultQuotaWindow)
+	if err == ErrLimitReached && q.ResetAt.After(now) {
+		q.SkipUntil = q.ResetAt
+	}
+}
+
+// quota returns the quota of the ith key, forgotten once its reset passes,
+// the lock must be held
+func (k *KeyPool) quota(i int, now time.Time) *KeyQuota {
+	if k.quotas == nil {
+		k.quotas = map[int]*KeyQuota{}
+	}
+	q, ok := k.quotas[i]
+	if !ok || (q.Known && !q.ResetAt.After(now) && !q.SkipUntil.After(now)) {
+		q = &KeyQuota{Key: k.Keys[i].Redacted()}
+		k.quotas[i] = q
+	}
+	return q
+}
+
+// redact replaces every key of the pool in s with its Redacted form
+func (k *KeyPool) redact(s string) string {
+	if k == nil {
+		return s
+	}
+	for _, key := range k.Keys {
+		s = key.redact(s)
+	}
+	return s
+}
@@ -0,0 +1,175 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP_test
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Shivam010/go-freeGeoIP"
+)
+
+// keyServer is the API server with a separate quota for every known key
+func keyServer(limits map[string]int, calls *int) *httptest.Server {
+	var mu sync.Mutex
+	used := map[string]int{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		*calls++
+		key := r.URL.Query().Get(freeGeoIP.DefaultAPIKeyName)
+		limit, ok := limits[key]
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		allowed := used[key] < limit
+		if allowed {
+			used[key]++
+		}
+		w.Header().Set("x-ratelimit-limit", strconv.Itoa(limit))
+		w.Header().Set("x-ratelimit-remaining", strconv.Itoa(limit-used[key]))
+		w.Header().Set("x-ratelimit-reset", "3600")
+		if !allowed {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{"ip":"` + strings.TrimPrefix(r.URL.Path, "/json/") + `","time_zone":""}`))
+	}))
+}
+
+func TestKeyPool(t *testing.T) {
+	small := &freeGeoIP.APIKey{Key: "small-key-0123456789"}
+	large := &freeGeoIP.APIKey{Key: "large-key-0123456789"}
+	invalid := &freeGeoIP.APIKey{Key: "invalid-key-0123456789"}
+	calls := 0
+	srv := keyServer(map[string]int{small.Key: 2, large.Key: 4}, &calls)
+	defer srv.Close()
+
+	buf := &bytes.Buffer{}
+	pool := freeGeoIP.NewKeyPool(small, large, invalid)
+	cli := &freeGeoIP.Client{
+		Cache:   freeGeoIP.DefaultCache(),
+		BaseURL: srv.URL,
+		KeyPool: pool,
+		Logger:  log.New(buf, "", 0),
+	}
+	ctx := context.Background()
+
+	served := map[string]int{}
+	for i := 1; i <= 6; i++ {
+		res := cli.GetGeoInfoFromString(ctx, "10.0.0."+strconv.Itoa(i))
+		if res.Error != nil {
+			t.Fatalf("GetGeoInfoFromString() #%v error = %v, want no error", i, res.Error)
+		}
+		served[res.Meta.APIKey]++
+
+		// cached responses need no key
+		if res = cli.GetGeoInfoFromString(ctx, "10.0.0."+strconv.Itoa(i)); !res.Cached {
+			t.Fatalf("GetGeoInfoFromString() #%v for second call output must be cached", i)
+		}
+	}
+	if served[small.Redacted()] != 2 || served[large.Redacted()] != 4 {
+		t.Fatalf("keys served got = %v", served)
+	}
+
+	// every key is skipped, no more API calls
+	before := calls
+	res := cli.GetGeoInfoFromString(ctx, "10.0.0.7")
+	if res.Error != freeGeoIP.ErrLimitReached {
+		t.Fatalf("GetGeoInfoFromString() error = %v, want %v", res.Error, freeGeoIP.ErrLimitReached)
+	}
+	if calls != before {
+		t.Fatalf("API calls got = %v, want %v", calls, before)
+	}
+
+	quotas := pool.Quotas()
+	if len(quotas) != 3 || quotas[0].Key != small.Redacted() || quotas[0].Remaining != 0 ||
+		quotas[1].Remaining != 0 || quotas[2].Known || quotas[2].SkipUntil.IsZero() {
+		t.Fatalf("Quotas() got = %+v", quotas)
+	}
+	for _, key := range []*freeGeoIP.APIKey{small, large, invalid} {
+		if strings.Contains(buf.String(), key.Key) {
+			t.Fatalf("logs must not contain the api key: %v", buf.String())
+		}
+	}
+}
+
+func TestKeyPoolMostRemaining(t *testing.T) {
+	first := &freeGeoIP.APIKey{Key: "first-key-0123456789"}
+	second := &freeGeoIP.APIKey{Key: "second-key-0123456789"}
+	calls := 0
+	srv := keyServer(map[string]int{first.Key: 3, second.Key: 10}, &calls)
+	defer srv.Close()
+
+	cli := &freeGeoIP.Client{BaseURL: srv.URL, KeyPool: freeGeoIP.NewKeyPool(first, second)}
+	ctx := context.Background()
+	want := []string{first.Redacted(), second.Redacted(), second.Redacted(), second.Redacted()}
+	for i, key := range want {
+		res := cli.GetGeoInfoFromString(ctx, dnsIP)
+		if res.Error != nil || res.Meta.APIKey != key {
+			t.Fatalf("GetGeoInfoFromString() #%v got = %v, %v, want key %v", i, res.Meta.APIKey, res.Error, key)
+		}
+	}
+}
+
+func TestKeyPoolUnknownSpread(t *testing.T) {
+	var keys []*freeGeoIP.APIKey
+	limits := map[string]int{}
+	for _, name := range []string{"alpha", "bravo", "charlie"} {
+		key := &freeGeoIP.APIKey{Key: name + "-key-0123456789"}
+		keys = append(keys, key)
+		limits[key.Key] = 100
+	}
+	calls := 0
+	srv := keyServer(limits, &calls)
+	defer srv.Close()
+
+	// the concurrent first requests, before any quota is known
+	cli := &freeGeoIP.Client{BaseURL: srv.URL, KeyPool: freeGeoIP.NewKeyPool(keys...)}
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		served = map[string]int{}
+	)
+	start := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			res := cli.GetGeoInfoFromString(context.Background(), "10.0.0."+strconv.Itoa(i))
+			if res.Error != nil {
+				t.Errorf("GetGeoInfoFromString() error = %v, want no error", res.Error)
+				return
+			}
+			mu.Lock()
+			served[res.Meta.APIKey]++
+			mu.Unlock()
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	if len(served) != 3 {
+		t.Fatalf("keys served got = %v, want every key once", served)
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LimitMode is the behaviour of the RateLimiter once the quota is exhausted
+type LimitMode int
+
+const (
+	// LimitBlock blocks the API call until the quota resets, or the context
+	// is done
+	LimitBlock LimitMode = iota
+	// LimitWait waits for the quota reset only if it happens before the
+	// context deadline, otherwise fails with `ErrLimitReached`
+	LimitWait
+	// LimitFailFast fails with `ErrLimitReached` without waiting
+	LimitFailFast
+)
+
+// DefaultLimiterBurst is the number of API calls a RateLimiter allows at once,
+// used when RateLimiter.Burst is not provided
+const DefaultLimiterBurst = 10
+
+// RateLimiter is the client-side rate limiter, which tracks the remaining quota
+// and its reset time from the API response headers, and stops the API calls
+// once the quota is exhausted, as per its Mode. It also paces the API calls so
+// that the remaining quota is spread till the reset time, allowing a burst of
+// at most Burst calls.
+// Its zero value is ready to use, with LimitBlock mode, and it can be shared
+// by multiple Clients using the same quota.
+type RateLimiter struct {
+	Mode  LimitMode
+	Burst int
+
+	mu        sync.Mutex
+	known     bool
+	remaining int64
+	resetAt   time.Time
+	tokens    float64
+	last      time.Time
+}
+
+// NewRateLimiter returns a RateLimiter with the provided mode
+func NewRateLimiter(mode LimitMode) *RateLimiter {
+	return &RateLimiter{Mode: mode}
+}
+
+// wait blocks until an API call is allowed, it returns `ErrLimitReached` if
+// the call is not allowed as per the Mode, or the context error
+func (l *RateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	for {
+		l.mu.Lock()
+		delay, err := l.reserve(ctx, time.Now())
+		l.mu.Unlock()
+		if err != nil || delay <= 0 {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return wrapError("limiter", ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// admit fails fast, as per the Mode, if the quota is already exhausted. Unlike
+// wait, it does not take any token, and is used before an API call is shared
+// by the callers with different contexts
+func (l *RateLimiter) admit(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	untilReset := time.Until(l.resetAt)
+	if !l.known || l.remaining > 0 || untilReset <= 0 {
+		return nil
+	}
+	_, err := l.delay(ctx, untilReset)
+	return err
+}
+
+// reserve takes a token for an API call, or returns the delay after which it
+// should be tried again, it must be called with mu held
+func (l *RateLimiter) reserve(ctx context.Context, now time.Time) (time.Duration, error) {
+	if !l.known {
+		return 0, nil
+	}
+	untilReset := l.resetAt.Sub(now)
+	if untilReset <= 0 {
+		// quota has been reset, its new value is known with the next response
+		l.known = false
+		return 0, nil
+	}
+
+	if l.remaining <= 0 {
+		return l.delay(ctx, untilReset)
+	}
+
+	// refill the tokens at the rate of remaining quota per reset duration
+	burst := float64(l.Burst)
+	if burst <= 0 {
+		burst = DefaultLimiterBurst
+	}
+	rate := float64(l.remaining) / untilReset.Seconds()
+	l.tokens += now.Sub(l.last).Seconds() * rate
+	if l.tokens > burst {
+		l.tokens = burst
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		l.remaining--
+		return 0, nil
+	}
+	return l.delay(ctx, time.Duration((1-l.tokens)/rate*float64(time.Second)))
+}
+
+// delay applies the Mode to the required delay
+func (l *RateLimiter) delay(ctx context.Context, d time.Duration) (time.Duration, error) {
+	switch l.Mode {
+	case LimitFailFast:
+		if l.remaining <= 0 {
+			return 0, ErrLimitReached
+		}
+	case LimitWait:
+		if deadline, ok := callerDeadline(ctx); ok && time.Until(deadline) < d {
+			return 0, ErrLimitReached
+		}
+	}
+	return d, nil
+}
+
+// callerDeadlineKey is the context key of the caller's deadline, which is kept
+// by the detached context of a shared lookup, see detachedContext
+type callerDeadlineKey struct{}
+
+// callerDeadline returns the deadline of the ctx, or of the caller whose
+// lookup is run with the detached ctx
+func callerDeadline(ctx context.Context) (time.Time, bool) {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline, true
+	}
+	deadline, ok := ctx.Value(callerDeadlineKey{}).(time.Time)
+	return deadline, ok
+}
+
+// observe updates the quota from the meta information of an API response,
+// limited reports whether the API responded with its limit reached
+func (l *RateLimiter) observe(meta *MetaInfo, limited bool) {
+	if l == nil || meta == nil {
+		return
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limited {
+		l.remaining = 0
+		if meta.ResetIn > 0 {
+			l.resetAt = now.Add(meta.ResetIn)
+		} else if !l.known || !l.resetAt.After(now) {
+			l.resetAt = now.Add(time.Hour)
+		}
+		l.known = true
+		return
+	}
+	if meta.Limit == 0 && meta.Remaining == 0 && meta.ResetIn == 0 {
+		return // no rate limit headers
+	}
+	if !l.known {
+		l.tokens = float64(l.Burst)
+		if l.Burst <= 0 {
+			l.tokens = DefaultLimiterBurst
+		}
+		l.last = now
+	}
+	l.known = true
+	l.remaining = meta.Remaining
+	l.resetAt = now.Add(meta.ResetIn)
+}
@@ -0,0 +1,214 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP"
+)
+
+// quotaServer responds with the rate limit headers for the quota of limit
+// calls per second
+func quotaServer(limit int32, calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(calls, 1)
+		remaining := limit - n
+		if remaining < 0 {
+			remaining = 0
+		}
+		w.Header().Set("x-ratelimit-limit", strconv.Itoa(int(limit)))
+		w.Header().Set("x-ratelimit-remaining", strconv.Itoa(int(remaining)))
+		w.Header().Set("x-ratelimit-reset", "1")
+		if n > limit {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{"ip":"` + dnsIP + `","time_zone":""}`))
+	}))
+}
+
+func TestRateLimiter_Modes(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    freeGeoIP.LimitMode
+		timeout time.Duration
+		err     error
+		calls   int32
+	}{
+		{
+			name:  "Fail Fast",
+			mode:  freeGeoIP.LimitFailFast,
+			err:   freeGeoIP.ErrLimitReached,
+			calls: 2,
+		},
+		{
+			name:    "Wait Till Deadline",
+			mode:    freeGeoIP.LimitWait,
+			timeout: 100 * time.Millisecond,
+			err:     freeGeoIP.ErrLimitReached,
+			calls:   2,
+		},
+		{
+			name:    "Block",
+			mode:    freeGeoIP.LimitBlock,
+			timeout: 100 * time.Millisecond,
+			calls:   2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			srv := quotaServer(2, &calls)
+			defer srv.Close()
+
+			cli := &freeGeoIP.Client{BaseURL: srv.URL, Limiter: freeGeoIP.NewRateLimiter(tt.mode)}
+			for i := 0; i < 2; i++ {
+				if res := cli.GetGeoInfoFromString(context.Background(), dnsIP); res.Error != nil {
+					t.Fatalf("GetGeoInfoFromString() error = %v, want no error", res.Error)
+				}
+			}
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			res := cli.GetGeoInfoFromString(ctx, dnsIP)
+			if tt.err != nil && res.Error != tt.err {
+				t.Fatalf("GetGeoInfoFromString() error = %v, want %v", res.Error, tt.err)
+			}
+			if tt.err == nil && res.Error == nil {
+				t.Fatalf("GetGeoInfoFromString() error = nil, want context error")
+			}
+			if calls != tt.calls {
+				t.Fatalf("API calls got = %v, want %v", calls, tt.calls)
+			}
+		})
+	}
+}
+
+func TestRateLimiter_WaitsForReset(t *testing.T) {
+	var calls int32
+	srv := quotaServer(1, &calls)
+	defer srv.Close()
+
+	cli := &freeGeoIP.Client{BaseURL: srv.URL, Limiter: &freeGeoIP.RateLimiter{}}
+	if res := cli.GetGeoInfoFromString(context.Background(), dnsIP); res.Error != nil {
+		t.Fatalf("GetGeoInfoFromString() error = %v, want no error", res.Error)
+	}
+	st := time.Now()
+	atomic.StoreInt32(&calls, 0) // quota resets on server
+	if res := cli.GetGeoInfoFromString(context.Background(), dnsIP); res.Error != nil {
+		t.Fatalf("GetGeoInfoFromString() error = %v, want no error", res.Error)
+	}
+	if since := time.Since(st); since < 900*time.Millisecond {
+		t.Fatalf("GetGeoInfoFromString() took %v, must block till the reset", since)
+	}
+}
+
+func TestRateLimiter_Pacing(t *testing.T) {
+	var calls int32
+	srv := quotaServer(10, &calls)
+	defer srv.Close()
+
+	cli := &freeGeoIP.Client{BaseURL: srv.URL, Limiter: &freeGeoIP.RateLimiter{Burst: 1}}
+	st := time.Now()
+	for i := 0; i < 4; i++ {
+		if res := cli.GetGeoInfoFromString(context.Background(), dnsIP); res.Error != nil {
+			t.Fatalf("GetGeoInfoFromString() error = %v, want no error", res.Error)
+		}
+	}
+	// quota of 10 calls per second, spread as 1 call per 100ms
+	if since := time.Since(st); since < 250*time.Millisecond {
+		t.Fatalf("GetGeoInfoFromString() calls took %v, must be paced", since)
+	}
+}
+
+// slowQuotaServer responds with the remaining quota of limit calls per 100
+// seconds, and the status for every call after the first
+func slowQuotaServer(limit int32, status int, calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(calls, 1)
+		w.Header().Set("x-ratelimit-limit", strconv.Itoa(int(limit)))
+		w.Header().Set("x-ratelimit-remaining", strconv.Itoa(int(limit-n)))
+		w.Header().Set("x-ratelimit-reset", "100")
+		if n > 1 && status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		_, _ = w.Write([]byte(`{"ip":"` + dnsIP + `","time_zone":""}`))
+	}))
+}
+
+func TestRateLimiter_PacingDeadline(t *testing.T) {
+	var calls int32
+	srv := slowQuotaServer(10, http.StatusOK, &calls)
+	defer srv.Close()
+
+	limiter := &freeGeoIP.RateLimiter{Mode: freeGeoIP.LimitWait, Burst: 1}
+	cli := &freeGeoIP.Client{BaseURL: srv.URL, Limiter: limiter}
+	for i := 0; i < 2; i++ {
+		if res := cli.GetGeoInfoFromString(context.Background(), dnsIP); res.Error != nil {
+			t.Fatalf("GetGeoInfoFromString() error = %v, want no error", res.Error)
+		}
+	}
+
+	// the next call is paced by ~11s, which is after the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	st := time.Now()
+	if res := cli.GetGeoInfoFromString(ctx, dnsIP); res.Error != freeGeoIP.ErrLimitReached {
+		t.Fatalf("GetGeoInfoFromString() error = %v, want %v", res.Error, freeGeoIP.ErrLimitReached)
+	}
+	if since := time.Since(st); since > 100*time.Millisecond {
+		t.Fatalf("GetGeoInfoFromString() took %v, must fail fast", since)
+	}
+	if calls != 2 {
+		t.Fatalf("API calls got = %v, want %v", calls, 2)
+	}
+}
+
+func TestRateLimiter_Retries(t *testing.T) {
+	var calls int32
+	srv := slowQuotaServer(10, http.StatusServiceUnavailable, &calls)
+	defer srv.Close()
+
+	cli := &freeGeoIP.Client{
+		BaseURL: srv.URL,
+		Limiter: &freeGeoIP.RateLimiter{Mode: freeGeoIP.LimitWait, Burst: 2},
+		Retry:   &freeGeoIP.RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond},
+	}
+	if res := cli.GetGeoInfoFromString(context.Background(), dnsIP); res.Error != nil {
+		t.Fatalf("GetGeoInfoFromString() error = %v, want no error", res.Error)
+	}
+
+	// the burst of 2 allows only 2 of the 5 attempts before the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if res := cli.GetGeoInfoFromString(ctx, dnsIP); res.Error != freeGeoIP.ErrLimitReached {
+		t.Fatalf("GetGeoInfoFromString() error = %v, want %v", res.Error, freeGeoIP.ErrLimitReached)
+	}
+	if calls != 3 {
+		t.Fatalf("API calls got = %v, want %v", calls, 3)
+	}
+}
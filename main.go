@@ -16,52 +16,100 @@ package freeGeoIP
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
-	"net/url"
 	"os"
-	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 const (
+	// Endpoint is the json endpoint of the freegeoip.app API.
+	//
+	// Deprecated: use Client.BaseURL and Client.URLTemplate instead
 	Endpoint = "https://freegeoip.app/json/"
 
+	// DefaultBaseURL is the base url of the API used when Client.BaseURL
+	// is not provided
+	DefaultBaseURL = "https://freegeoip.app"
+	// DefaultURLTemplate is the url layout used when Client.URLTemplate is
+	// not provided. The placeholders {base}, {format} and {query} are replaced
+	// with the base url, the response format and the queried ip respectively.
+	// A query string layout, like "{base}/lookup?format={format}&ip={query}",
+	// is also supported
+	DefaultURLTemplate = "{base}/{format}/{query}"
+
 	_HeaderResetIn   = "x-ratelimit-reset"
 	_HeaderLimit     = "x-ratelimit-limit"
 	_HeaderRemaining = "x-ratelimit-remaining"
 )
 
 var (
-	// globalMeta maintains the API meta information for the cached information
-	// and mu protects globalMeta against updates
-	mu         *sync.Mutex
-	globalMeta = &MetaInfo{}
 	// noopLogger is the quiet logger to handle nil logger in Client
 	noopLogger = log.New(ioutil.Discard, "", 0)
 	// defaultLogger is the default logger implementation for DefaultClient
 	defaultLogger = log.New(os.Stderr, "freeGeoIP ", log.LstdFlags)
 )
 
-func init() {
-	mu = &sync.Mutex{}
-	mu.Lock()
-	globalMeta.Limit = 15000
-	globalMeta.Remaining = globalMeta.Limit
-	globalMeta.ResetIn = time.Hour
-	mu.Unlock()
-}
-
 // Client is our Free GeoLocation information client.
 // If Cache is not provided then will always make the http request to https://freegeoip.app/json/
 // If HttpCli is not provided then will always use http.DefaultClient
 // And if Logger is not provided, then a noopLogger will be used
+// BaseURL and URLTemplate can be used to point the Client to any freegeoip
+// compatible deployment, if not provided DefaultBaseURL and DefaultURLTemplate
+// will be used
+// If Format is not provided, the json responses are requested
+// If APIKey is provided, it is sent with every request for the authenticated
+// API tiers
+// If KeyPool is provided, its keys are rotated as per their quota instead of
+// the APIKey, and the Limiter is not used
+// If Provider is provided, it is used for the lookups instead of the
+// freegeoip API, and the HttpCli, BaseURL, URLTemplate, Format, APIKey,
+// KeyPool, Retry and Limiter are not used, see HTTPProvider to configure them
+// for the freegeoip API
+// If Resolver is provided, hostnames are resolved locally, otherwise they are
+// passed to the API as it is, if the Provider implements HostProvider, or are
+// resolved with the net.DefaultResolver
+// If Retry is provided, the transient failures of API are retried as per it,
+// otherwise every API call is attempted once
+// If Limiter is provided, the API calls are paced and stopped as per the
+// remaining quota, before reaching the API limit
+// If QuotaTracker is not provided, the Client tracks its quota on its own,
+// it can be shared by multiple Clients using the same quota
 type Client struct {
 	Cache   ICache
 	HttpCli *http.Client
 	Logger  *log.Logger
+
+	BaseURL     string
+	URLTemplate string
+	Format      Format
+	APIKey      *APIKey
+	KeyPool     *KeyPool
+
+	Provider Provider
+	Resolver Resolver
+	Retry    *RetryPolicy
+	Limiter  *RateLimiter
+
+	QuotaTracker *QuotaTracker
+
+	// once sets the defaults of the Client
+	once sync.Once
+	// quota is the QuotaTracker used when QuotaTracker is not provided
+	quota QuotaTracker
+	// flight collapses the concurrent API calls for the same query
+	flight flightGroup
+}
+
+// Resolver is used to resolve the hostnames into ip addresses before looking
+// them up, *net.Resolver can be used as a Resolver
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
 }
 
 // DefaultClient is the library default geo location client with an in-memory
@@ -76,139 +124,215 @@ func DefaultClient() *Client {
 }
 
 // GetGeoInfoFromString will return the API response for provided `ip` string
-// It call the GetGeoInfo.
+// It call the GetGeoInfo, or the GetGeoInfoForHost if `ip` is a hostname.
 func (c *Client) GetGeoInfoFromString(ctx context.Context, ip string) Response {
 	_ip := ParseIP(ip)
 	if len(_ip) == 0 && ip != "" {
-		return fillResponse(nil, ErrNoResponse, nil, struct{}{})
+		return c.GetGeoInfoForHost(ctx, ip)
 	}
 	return c.GetGeoInfo(ctx, _ip)
 }
 
 // GetGeoInfo will return the free geolocation api response for the provided IP
-// and uses the Cached response, if cache is used. For default empty Client
-// behaviour see Client object description
+// and uses the Cached response, if cache is used. Concurrent calls for the same
+// uncached IP share a single API call. The cached information without a city
+// is not used for the PrecisionCity hint of the ctx, see WithPrecision. For
+// default empty Client behaviour see Client object description
 func (c *Client) GetGeoInfo(ctx context.Context, ip IP) Response {
-	if c.Logger == nil {
-		c.Logger = noopLogger
-	}
-	if c.HttpCli == nil {
-		c.HttpCli = http.DefaultClient
-	}
-	if c.Cache == nil {
-		c.Cache = NoopCache{}
-	}
+	c.init()
 	// check cache
 	info, err := c.Cache.Get(ctx, ip)
+	if err == nil && !precise(ctx, info) {
+		err = errImprecise
+	}
 	if err == nil {
-		c.Logger.Println("cache is hit for '" + ip.String())
-		return fillResponse(info, nil, nil, struct{}{})
+		c.log("cache is hit for '" + ip.String())
+		return c.fillResponse(info, nil, nil, struct{}{})
 	}
-	c.Logger.Println("cache for '"+ip.String()+"' is missed with error:", err)
+	c.log("cache for '"+ip.String()+"' is missed with error:", err)
 	// call api
-	return c.do(ctx, ip)
+	return c.fetch(ctx, ip.String())
 }
 
-// do is the internal method used to make the http request to API
-func (c *Client) do(ctx context.Context, ip IP) Response {
-	// http request
-	u, _ := url.Parse(Endpoint)
-	u.Path += ip.String()
-	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
-	if err != nil {
-		c.Logger.Println("http.NewRequest error:", err)
-		return fillResponse(nil, wrapError("http", err), nil)
+// GetGeoInfoForHost will return the free geolocation api response for the
+// provided hostname. If Resolver is provided then the hostname is resolved
+// locally and the information of its first ip is returned, otherwise the
+// hostname is passed to the API, if the Provider implements HostProvider, or
+// is resolved with the net.DefaultResolver. The response is cached under the
+// hostname, if Cache implements IHostCache, as well as under the resolved ip.
+func (c *Client) GetGeoInfoForHost(ctx context.Context, host string) Response {
+	c.init()
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if ip := ParseIP(host); len(ip) != 0 {
+		return c.GetGeoInfo(ctx, ip)
 	}
-
-	// request's response
-	resp, err := c.HttpCli.Do(req.WithContext(ctx))
-	if err != nil {
-		c.Logger.Println("http response error:", err)
-		return fillResponse(nil, wrapError("http", err), nil)
+	if !validHost(host) {
+		return c.fillResponse(nil, ErrNoResponse, nil, struct{}{})
+	}
+	// check host cache
+	hc, _ := c.Cache.(IHostCache)
+	if hc != nil {
+		info, err := hc.GetHost(ctx, host)
+		if err == nil && !precise(ctx, info) {
+			err = errImprecise
+		}
+		if err == nil {
+			c.log("cache is hit for '" + host)
+			return c.fillResponse(info, nil, nil, struct{}{})
+		}
+		c.log("cache for '"+host+"' is missed with error:", err)
 	}
-	defer resp.Body.Close()
-
-	// meta information
-	meta := extractMetaInfo(resp.Header)
 
-	// rate limit check
-	if resp.StatusCode == http.StatusForbidden {
-		c.Logger.Println(ErrLimitReached)
-		return fillResponse(nil, ErrLimitReached, meta)
+	resolver := c.Resolver
+	if _, ok := c.provider().(HostProvider); !ok && resolver == nil {
+		resolver = net.DefaultResolver
 	}
 
-	// invalid ip
-	if resp.StatusCode == http.StatusNotFound {
-		c.Logger.Println(ErrNoResponse)
-		return fillResponse(nil, ErrNoResponse, meta)
+	var res Response
+	if resolver != nil {
+		addrs, err := resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			c.log("resolve error:", err)
+			return c.fillResponse(nil, wrapError("resolve", err), nil)
+		}
+		if len(addrs) == 0 {
+			c.log(ErrNoResponse)
+			return c.fillResponse(nil, ErrNoResponse, nil)
+		}
+		res = c.GetGeoInfo(ctx, IP(addrs[0].IP))
+	} else {
+		res = c.fetch(ctx, host)
+	}
+	if res.Info != nil && hc != nil && (res.Meta == nil || !res.Meta.uncached) {
+		hc.SetHost(ctx, host, res.Info)
 	}
+	return res
+}
+
+// init sets the defaults for the fields not provided in Client, only once so
+// that the Client is safe for the concurrent use
+func (c *Client) init() {
+	c.once.Do(func() {
+		if c.Logger == nil {
+			c.Logger = noopLogger
+		}
+		if c.HttpCli == nil {
+			c.HttpCli = http.DefaultClient
+		}
+		if c.Cache == nil {
+			c.Cache = NoopCache{}
+		}
+	})
+}
 
-	// non ok status code
-	if resp.StatusCode != http.StatusOK {
-		data, _ := ioutil.ReadAll(resp.Body)
-		c.Logger.Println(ErrInternal, "status:", resp.Status, "response:", string(data))
-		return fillResponse(nil, ErrInternal, meta)
+// fetch looks up the query with the Provider, but only once for all of the
+// concurrent callers of the same query, every caller gets its own copy of the
+// response
+func (c *Client) fetch(ctx context.Context, query string) Response {
+	p := c.provider()
+	if hp, ok := p.(*HTTPProvider); ok {
+		if err := hp.limiter().admit(ctx); err != nil {
+			c.log("rate limiter error:", err)
+			return c.fillResponse(nil, err, nil)
+		}
+	}
+	// the lookups for the city are not shared with the ones which may be
+	// answered with only the country
+	key := query
+	if PrecisionFromContext(ctx) == PrecisionCity {
+		key += "\x00city"
+	}
+	res, shared := c.flight.do(ctx, key, func(ctx context.Context) Response {
+		return c.lookup(ctx, p, query)
+	})
+	if shared {
+		c.log("api call for '" + query + "' is shared")
 	}
+	if res.Meta != nil {
+		meta := *res.Meta
+		res.Meta = &meta
+	}
+	return c.fillResponse(res.Info, res.Error, res.Meta)
+}
 
-	// finally response
-	data, err := ioutil.ReadAll(resp.Body)
+// lookup looks up the query, an ip or a hostname, with the provider and
+// caches the found information
+func (c *Client) lookup(ctx context.Context, p Provider, query string) Response {
+	var (
+		info *Info
+		meta *MetaInfo
+		err  error
+	)
+	if hp, ok := p.(HostProvider); ok && query != "" && len(ParseIP(query)) == 0 {
+		info, meta, err = hp.LookupHost(ctx, query)
+	} else {
+		info, meta, err = p.Lookup(ctx, ParseIP(query))
+	}
+	c.tracker().update(meta)
 	if err != nil {
-		c.Logger.Println("unreadable response body:", err)
-		return fillResponse(nil, wrapError("response", err), meta)
+		return c.fillResponse(nil, err, meta)
 	}
 
-	// decode
-	info, err := Decoder(data)
-	if info != nil {
+	if info != nil && (meta == nil || !meta.uncached) {
 		c.Cache.Set(ctx, info)
-		if len(ip) == 0 { // hack: to cache empty ip for next call
+		if query == "" { // hack: to cache empty ip for next call
 			tmp := info.IP
 			info.IP = nil
 			c.Cache.Set(ctx, info)
 			info.IP = tmp
 		}
 	}
-	return fillResponse(info, err, meta)
+	return c.fillResponse(info, err, meta)
 }
 
-// extractMetaInfo extract the meta details regarding the limit and reset timer
-// from the API response headers
-func extractMetaInfo(header http.Header) *MetaInfo {
-	atoi := func(key string) int64 {
-		v, _ := strconv.Atoi(header.Get(key))
-		return int64(v)
-	}
-	meta := &MetaInfo{
-		ResetIn:   time.Second * time.Duration(atoi(_HeaderResetIn)),
-		Limit:     atoi(_HeaderLimit),
-		Remaining: atoi(_HeaderRemaining),
-	}
-	mu.Lock()
-	globalMeta.Limit = meta.Limit
-	globalMeta.Remaining = meta.Remaining
-	globalMeta.ResetIn = meta.ResetIn
-	mu.Unlock()
-	return meta
+// validHost reports whether the host is a syntactically valid hostname
+func validHost(host string) bool {
+	if len(host) == 0 || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return false
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+				return false
+			}
+		}
+	}
+	return true
 }
 
-// fillResponse returns a combined response for any client method call
-func fillResponse(info *Info, err error, meta *MetaInfo, cached ...struct{}) Response {
-	if info != nil { // create a copy of info
-		tmp := *info
-		info = &tmp
-	}
+// log prints to the Logger of the Client, with the API keys redacted
+func (c *Client) log(v ...interface{}) {
+	_ = c.Logger.Output(2, c.KeyPool.redact(c.APIKey.redact(fmt.Sprintln(v...))))
+}
+
+// fillResponse returns a combined response for any client method call, with
+// the current quota of the Client, if meta is not provided
+func (c *Client) fillResponse(info *Info, err error, meta *MetaInfo, cached ...struct{}) Response {
 	res := Response{
-		Info:   info,
+		Info:   copyInfo(info),
 		Error:  err,
 		Cached: len(cached) > 0,
 		Meta:   meta,
 	}
 	if res.Meta == nil {
-		res.Meta = &MetaInfo{
-			ResetIn:   globalMeta.ResetIn,
-			Limit:     globalMeta.Limit,
-			Remaining: globalMeta.Remaining,
-		}
+		meta := c.Quota()
+		res.Meta = &meta
 	}
 	return res
 }
+
+// copyInfo returns a copy of info, so that the cached info is never
+// manipulated through a response
+func copyInfo(info *Info) *Info {
+	if info == nil {
+		return nil
+	}
+	tmp := *info
+	return &tmp
+}
@@ -16,14 +16,37 @@ package freeGeoIP_test
 
 import (
 	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/Shivam010/go-freeGeoIP"
+	"github.com/Shivam010/go-freeGeoIP/freegeoiptest"
 )
 
+// fakeAPI starts the fake API answering the test ips, and the empty query
+func fakeAPI() *freegeoiptest.Server {
+	return freegeoiptest.NewServer(map[string]*freeGeoIP.Info{
+		responseIP:  response(),
+		broadcastIP: broadcastResponse(),
+		"127.0.0.1": {IP: freeGeoIP.ParseIP("127.0.0.1"), TimeZone: freeGeoIP.LocationF(time.UTC)},
+	})
+}
+
+// fakeClient returns the DefaultClient using the fake API
+func fakeClient(srv *freegeoiptest.Server) *freeGeoIP.Client {
+	cli := freeGeoIP.DefaultClient()
+	cli.BaseURL = srv.URL
+	return cli
+}
+
 func TestGetGeoInfo_DefaultClient(t *testing.T) {
 	// default client with cache
-	cli := freeGeoIP.DefaultClient()
+	srv := fakeAPI()
+	defer srv.Close()
+	cli := fakeClient(srv)
 	ctx := context.Background()
 
 	// first call for normal response IP
@@ -72,7 +95,9 @@ func TestGetGeoInfo_DefaultClient(t *testing.T) {
 
 func TestGetGeoInfo_EmptyClient(t *testing.T) {
 	// empty client with no cache
-	cli := &freeGeoIP.Client{}
+	srv := fakeAPI()
+	defer srv.Close()
+	cli := &freeGeoIP.Client{BaseURL: srv.URL}
 	ctx := context.Background()
 
 	// first call for normal response IP
@@ -122,7 +147,9 @@ func TestGetGeoInfo_EmptyClient(t *testing.T) {
 
 func TestInvalidInput(t *testing.T) {
 	// default client with cache
-	cli := freeGeoIP.DefaultClient()
+	srv := fakeAPI()
+	defer srv.Close()
+	cli := fakeClient(srv)
 	ctx := context.Background()
 
 	res := cli.GetGeoInfoFromString(ctx, "responseIP")
@@ -138,7 +165,9 @@ func TestInvalidInput(t *testing.T) {
 
 func TestNoInput(t *testing.T) {
 	// default client with cache
-	cli := freeGeoIP.DefaultClient()
+	srv := fakeAPI()
+	defer srv.Close()
+	cli := fakeClient(srv)
 	ctx := context.Background()
 
 	res := cli.GetGeoInfoFromString(ctx, "")
@@ -170,7 +199,9 @@ func TestNoInput(t *testing.T) {
 
 func TestManipulatingGotResponse(t *testing.T) {
 	// default client with cache
-	cli := freeGeoIP.DefaultClient()
+	srv := fakeAPI()
+	defer srv.Close()
+	cli := fakeClient(srv)
 	ctx := context.Background()
 
 	res := cli.GetGeoInfoFromString(ctx, broadcastIP)
@@ -202,6 +233,156 @@ func TestManipulatingGotResponse(t *testing.T) {
 	}
 }
 
+func TestClientBaseURLAndTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		query    string
+		wantURI  string
+	}{
+		{
+			name:    "Default Template",
+			query:   dnsIP,
+			wantURI: "/json/" + dnsIP,
+		},
+		{
+			name:     "Path Template",
+			template: "{base}/v1/{format}/{query}",
+			query:    responseIP,
+			wantURI:  "/v1/json/" + responseIP,
+		},
+		{
+			name:     "Query String Template",
+			template: "{base}/lookup?format={format}&ip={query}",
+			query:    responseIP,
+			wantURI:  "/lookup?format=json&ip=2401%3A4900%3A16ff%3Af1ef%3Afff5%3Af63e%3A8a25%3Aa38a",
+		},
+		{
+			name:    "No Input",
+			wantURI: "/json/",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotURI string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotURI = r.URL.RequestURI()
+				_, _ = w.Write([]byte(`{"ip":"` + dnsIP + `","time_zone":""}`))
+			}))
+			defer srv.Close()
+
+			cli := &freeGeoIP.Client{BaseURL: srv.URL + "/", URLTemplate: tt.template}
+			res := cli.GetGeoInfoFromString(context.Background(), tt.query)
+			if err := res.Error; err != nil {
+				t.Fatalf("GetGeoInfoFromString() error = %v, want no error", err)
+			}
+			if gotURI != tt.wantURI {
+				t.Fatalf("request uri got = %v, want %v", gotURI, tt.wantURI)
+			}
+		})
+	}
+}
+
+func TestClientFormat(t *testing.T) {
+	var gotURI string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURI = r.URL.RequestURI()
+		_, _ = w.Write([]byte("2401:4900:16ff:f1ef:fff5:f63e:8a25:a38a,IN,India,KA,Karnataka,Belgaum,590006,Asia/Kolkata,15.8521,74.5045,0\r\n"))
+	}))
+	defer srv.Close()
+
+	cli := &freeGeoIP.Client{BaseURL: srv.URL, Format: freeGeoIP.FormatCSV}
+	res := cli.GetGeoInfoFromString(context.Background(), responseIP)
+	if err := res.Error; err != nil {
+		t.Fatalf("GetGeoInfoFromString() error = %v, want no error", err)
+	}
+	if want := "/csv/" + responseIP; gotURI != want {
+		t.Fatalf("request uri got = %v, want %v", gotURI, want)
+	}
+	compare(t, res.Info, response())
+
+	cli.Format = "yaml"
+	if res = cli.GetGeoInfoFromString(context.Background(), responseIP); res.Error == nil {
+		t.Fatalf("GetGeoInfoFromString() error = nil, want unsupported format error")
+	}
+}
+
+// fakeResolver resolves every hostname to ips
+type fakeResolver []string
+
+func (f fakeResolver) LookupIPAddr(context.Context, string) ([]net.IPAddr, error) {
+	var addrs []net.IPAddr
+	for _, ip := range f {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+func TestGetGeoInfoForHost(t *testing.T) {
+	tests := []struct {
+		name     string
+		resolver freeGeoIP.Resolver
+		wantURI  string
+	}{
+		{
+			name:    "Passed To API",
+			wantURI: "/json/example.com",
+		},
+		{
+			name:     "Resolved Locally",
+			resolver: fakeResolver{responseIP},
+			wantURI:  "/json/" + responseIP,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotURI string
+			calls := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotURI = r.URL.RequestURI()
+				calls++
+				_, _ = w.Write([]byte(`{"ip":"` + responseIP + `","country_code":"IN","time_zone":""}`))
+			}))
+			defer srv.Close()
+
+			cli := &freeGeoIP.Client{Cache: freeGeoIP.DefaultCache(), BaseURL: srv.URL, Resolver: tt.resolver}
+			ctx := context.Background()
+
+			res := cli.GetGeoInfoFromString(ctx, "Example.com.")
+			if err := res.Error; err != nil {
+				t.Fatalf("GetGeoInfoFromString() error = %v, want no error", err)
+			}
+			if res.Cached {
+				t.Fatalf("GetGeoInfoFromString() for new call output must not be cached")
+			}
+			if gotURI != tt.wantURI {
+				t.Fatalf("request uri got = %v, want %v", gotURI, tt.wantURI)
+			}
+
+			// hostname and resolved ip, both must be cached
+			if sec := cli.GetGeoInfoForHost(ctx, "example.com"); !sec.Cached || sec.Info.CountryCode != "IN" {
+				t.Fatalf("GetGeoInfoForHost() for second call output must be cached, got %+v", sec)
+			}
+			if thr := cli.GetGeoInfoFromString(ctx, responseIP); !thr.Cached {
+				t.Fatalf("GetGeoInfoFromString() for resolved ip output must be cached")
+			}
+			if calls != 1 {
+				t.Fatalf("API calls got = %v, want %v", calls, 1)
+			}
+		})
+	}
+}
+
+func TestInvalidHost(t *testing.T) {
+	cli := &freeGeoIP.Client{BaseURL: "http://127.0.0.1:0"}
+	for _, host := range []string{"?00", "-example.com", "example..com", "exa mple.com"} {
+		res := cli.GetGeoInfoForHost(context.Background(), host)
+		if err := res.Error; err != freeGeoIP.ErrNoResponse {
+			t.Fatalf("GetGeoInfoForHost(%q) error = %v, want %v", host, err, freeGeoIP.ErrNoResponse)
+		}
+	}
+}
+
 func Testd() {
 
 }
@@ -0,0 +1,229 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mmdb
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// data types of the MaxMind DB data section
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// maxDepth limits the nesting of the decoded values, to protect against the
+// pointer loops in a corrupt database
+const maxDepth = 64
+
+// ErrCorrupt is returned when the database can not be decoded
+var ErrCorrupt = errors.New("mmdb: corrupt database")
+
+// decoder decodes the values of a MaxMind DB data section, the offsets are
+// relative to the start of the section
+type decoder struct {
+	buf []byte
+}
+
+// decode returns the value at the offset and the offset of the next value.
+// Maps are decoded as map[string]interface{}, arrays as []interface{},
+// unsigned integers as uint64, int32 as int64, uint128 as *big.Int, doubles
+// and floats as float64, and bytes as []byte.
+func (d *decoder) decode(offset uint, depth int) (interface{}, uint, error) {
+	if depth > maxDepth {
+		return nil, 0, ErrCorrupt
+	}
+	typ, size, offset, err := d.ctrl(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if typ == typePointer {
+		ptr, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		val, _, err := d.decode(ptr, depth+1)
+		return val, next, err
+	}
+	return d.value(typ, size, offset, depth)
+}
+
+// ctrl decodes the control byte(s) at offset and returns the type and the size
+// of the value, and the offset of its payload. For the pointers, size is the
+// lower 5 bits of the control byte.
+func (d *decoder) ctrl(offset uint) (int, uint, uint, error) {
+	if offset >= uint(len(d.buf)) {
+		return 0, 0, 0, ErrCorrupt
+	}
+	c := d.buf[offset]
+	offset++
+	typ := int(c >> 5)
+	if typ == typePointer {
+		return typ, uint(c & 0x1f), offset, nil
+	}
+	if typ == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return 0, 0, 0, ErrCorrupt
+		}
+		typ = 7 + int(d.buf[offset])
+		offset++
+		if typ <= typeMap {
+			return 0, 0, 0, ErrCorrupt
+		}
+	}
+
+	size := uint(c & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.buf)) {
+			return 0, 0, 0, ErrCorrupt
+		}
+		ext := uint(beUint(d.buf[offset : offset+n]))
+		offset += n
+		switch n {
+		case 1:
+			size = 29 + ext
+		case 2:
+			size = 285 + ext
+		default:
+			size = 65821 + ext
+		}
+	}
+	return typ, size, offset, nil
+}
+
+// pointer decodes the pointer payload at offset, bits are the lower 5 bits
+// of its control byte
+func (d *decoder) pointer(bits, offset uint) (uint, uint, error) {
+	n := (bits>>3)&3 + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, ErrCorrupt
+	}
+	val := uint(beUint(d.buf[offset : offset+n]))
+	prefix := bits & 7
+	switch n {
+	case 1:
+		val = prefix<<8 | val
+	case 2:
+		val = (prefix<<16 | val) + 2048
+	case 3:
+		val = (prefix<<24 | val) + 526336
+	}
+	return val, offset + n, nil
+}
+
+// value decodes the payload of the type and size at offset
+func (d *decoder) value(typ int, size, offset uint, depth int) (interface{}, uint, error) {
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, ErrCorrupt
+			}
+			val, next, err := d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[k] = val
+			offset = next
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			val, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, val)
+			offset = next
+		}
+		return a, offset, nil
+	case typeBool:
+		if size > 1 {
+			return nil, 0, ErrCorrupt
+		}
+		return size == 1, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, ErrCorrupt
+	}
+	payload, next := d.buf[offset:offset+size], offset+size
+	switch typ {
+	case typeString:
+		return string(payload), next, nil
+	case typeBytes:
+		return append([]byte(nil), payload...), next, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, ErrCorrupt
+		}
+		return math.Float64frombits(beUint(payload)), next, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, ErrCorrupt
+		}
+		return float64(math.Float32frombits(uint32(beUint(payload)))), next, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > map[int]uint{typeUint16: 2, typeUint32: 4, typeUint64: 8}[typ] {
+			return nil, 0, ErrCorrupt
+		}
+		return beUint(payload), next, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, ErrCorrupt
+		}
+		return int64(int32(uint32(beUint(payload)))), next, nil
+	case typeUint128:
+		if size > 16 {
+			return nil, 0, ErrCorrupt
+		}
+		return new(big.Int).SetBytes(payload), next, nil
+	}
+	return nil, 0, errors.New("mmdb: unsupported data type " + strconv.Itoa(typ))
+}
+
+// beUint decodes the big endian unsigned integer of at most 8 bytes
+func beUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
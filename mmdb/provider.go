@@ -0,0 +1,124 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mmdb
+
+import (
+	"context"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP"
+)
+
+// DefaultLanguage is the language of the names used when Reader.Language is
+// not provided, or the name is not available in Reader.Language
+const DefaultLanguage = "en"
+
+// Lookup implements the freeGeoIP.Provider, mapping the GeoLite2-City (or a
+// compatible) record of the ip into the freeGeoIP.Info. The empty ip can not
+// be looked up offline and `freeGeoIP.ErrNoResponse` is returned for it, as
+// well as for the ips not found in the database. No MetaInfo is returned, as
+// there is no quota for the offline lookups.
+func (r *Reader) Lookup(_ context.Context, ip freeGeoIP.IP) (*freeGeoIP.Info, *freeGeoIP.MetaInfo, error) {
+	if len(ip) == 0 {
+		return nil, nil, freeGeoIP.ErrNoResponse
+	}
+	record, found, err := r.Record(ip.Net())
+	if err != nil {
+		return nil, nil, err
+	}
+	if !found {
+		return nil, nil, freeGeoIP.ErrNoResponse
+	}
+	return r.info(ip, record), nil, nil
+}
+
+// info maps the record into the freeGeoIP.Info
+func (r *Reader) info(ip freeGeoIP.IP, record interface{}) *freeGeoIP.Info {
+	country := get(record, "country")
+	if country == nil {
+		country = get(record, "registered_country")
+	}
+	subdivision := get(record, "subdivisions", 0)
+
+	info := &freeGeoIP.Info{
+		IP:          ip,
+		CountryCode: str(get(country, "iso_code")),
+		CountryName: r.name(country),
+		RegionCode:  str(get(subdivision, "iso_code")),
+		RegionName:  r.name(subdivision),
+		City:        r.name(get(record, "city")),
+		ZipCode:     str(get(record, "postal", "code")),
+		MetroCode:   num(get(record, "location", "metro_code")),
+		Latitude:    num(get(record, "location", "latitude")),
+		Longitude:   num(get(record, "location", "longitude")),
+	}
+	if zone, err := time.LoadLocation(str(get(record, "location", "time_zone"))); err == nil {
+		info.TimeZone = freeGeoIP.LocationF(zone)
+	}
+	return info
+}
+
+// name returns the name of the record in the Reader's language
+func (r *Reader) name(record interface{}) string {
+	lang := r.Language
+	if lang == "" {
+		lang = DefaultLanguage
+	}
+	if name := str(get(record, "names", lang)); name != "" {
+		return name
+	}
+	return str(get(record, "names", DefaultLanguage))
+}
+
+// get returns the value at the path in the decoded record, the string path
+// elements are the map keys and the int ones are the array indexes
+func get(val interface{}, path ...interface{}) interface{} {
+	for _, p := range path {
+		switch key := p.(type) {
+		case string:
+			m, ok := val.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			val = m[key]
+		case int:
+			a, ok := val.([]interface{})
+			if !ok || key >= len(a) {
+				return nil
+			}
+			val = a[key]
+		}
+	}
+	return val
+}
+
+// str returns the decoded string value, or empty string
+func str(val interface{}) string {
+	s, _ := val.(string)
+	return s
+}
+
+// num returns the decoded numeric value as float64, or zero
+func num(val interface{}) float64 {
+	switch n := val.(type) {
+	case float64:
+		return n
+	case uint64:
+		return float64(n)
+	case int64:
+		return float64(n)
+	}
+	return 0
+}
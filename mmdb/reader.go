@@ -0,0 +1,223 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mmdb is a pure Go reader for the MaxMind DB files, like GeoLite2-City,
+// which can be used as an offline freeGeoIP.Provider, without any network
+// call or quota.
+package mmdb
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+// metadataStart marks the start of the metadata section of the database
+var metadataStart = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// Metadata is the metadata of the MaxMind DB
+type Metadata struct {
+	NodeCount    uint
+	RecordSize   uint
+	IPVersion    uint
+	DatabaseType string
+	Languages    []string
+	Description  map[string]string
+	BuildEpoch   uint64
+}
+
+// Reader looks up the records of a MaxMind DB, which is read once and kept in
+// memory. It is safe for the concurrent use.
+// Language is the language of the names mapped to the freeGeoIP.Info, if not
+// provided DefaultLanguage will be used
+type Reader struct {
+	Language string
+
+	buf       []byte
+	meta      Metadata
+	nodeSize  uint
+	ipv4Start uint
+	data      decoder
+}
+
+// Open reads the MaxMind DB file at path
+func Open(path string) (*Reader, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return FromBytes(buf)
+}
+
+// FromBytes returns the Reader for the MaxMind DB in buf, buf must not be
+// modified afterwards
+func FromBytes(buf []byte) (*Reader, error) {
+	start := bytes.LastIndex(buf, metadataStart)
+	if start < 0 {
+		return nil, errors.New("mmdb: invalid database, metadata not found")
+	}
+	md := &decoder{buf: buf[start+len(metadataStart):]}
+	val, _, err := md.decode(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := parseMetadata(val)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reader{buf: buf, meta: meta, nodeSize: meta.RecordSize / 4}
+	treeSize := meta.NodeCount * r.nodeSize
+	if treeSize+16 > uint(start) {
+		return nil, ErrCorrupt
+	}
+	r.data = decoder{buf: buf[treeSize+16 : start]}
+
+	// ipv4 addresses are stored at ::/96 subtree in the ipv6 databases
+	if meta.IPVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < meta.NodeCount; i++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// parseMetadata maps the decoded metadata section into Metadata
+func parseMetadata(val interface{}) (Metadata, error) {
+	m, ok := val.(map[string]interface{})
+	if !ok {
+		return Metadata{}, ErrCorrupt
+	}
+	num := func(key string) uint64 {
+		v, _ := m[key].(uint64)
+		return v
+	}
+	meta := Metadata{
+		NodeCount:   uint(num("node_count")),
+		RecordSize:  uint(num("record_size")),
+		IPVersion:   uint(num("ip_version")),
+		BuildEpoch:  num("build_epoch"),
+		Description: map[string]string{},
+	}
+	meta.DatabaseType, _ = m["database_type"].(string)
+	if langs, ok := m["languages"].([]interface{}); ok {
+		for _, l := range langs {
+			if s, ok := l.(string); ok {
+				meta.Languages = append(meta.Languages, s)
+			}
+		}
+	}
+	if desc, ok := m["description"].(map[string]interface{}); ok {
+		for k, v := range desc {
+			if s, ok := v.(string); ok {
+				meta.Description[k] = s
+			}
+		}
+	}
+
+	switch meta.RecordSize {
+	case 24, 28, 32:
+	default:
+		return meta, errors.New("mmdb: unsupported record size")
+	}
+	if meta.IPVersion != 4 && meta.IPVersion != 6 {
+		return meta, errors.New("mmdb: unsupported ip version")
+	}
+	return meta, nil
+}
+
+// Metadata returns the metadata of the database
+func (r *Reader) Metadata() Metadata {
+	return r.meta
+}
+
+// BuildTime returns the time at which the database was built
+func (r *Reader) BuildTime() time.Time {
+	return time.Unix(int64(r.meta.BuildEpoch), 0)
+}
+
+// Record returns the decoded data record of the ip, see decoder.decode for
+// the decoded types. The found is false if the database has no record for it.
+func (r *Reader) Record(ip net.IP) (record interface{}, found bool, err error) {
+	offset, found, err := r.lookup(ip)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	record, _, err = r.data.decode(offset, 0)
+	if err != nil {
+		return nil, false, err
+	}
+	return record, true, nil
+}
+
+// lookup walks the search tree for the ip and returns the offset of its
+// record in the data section
+func (r *Reader) lookup(ip net.IP) (uint, bool, error) {
+	var (
+		bits []byte
+		node uint
+	)
+	if ip4 := ip.To4(); ip4 != nil {
+		bits, node = ip4, r.ipv4Start
+	} else if ip16 := ip.To16(); ip16 != nil && r.meta.IPVersion == 6 {
+		bits = ip16
+	} else if ip16 != nil {
+		return 0, false, errors.New("mmdb: ipv6 lookup in an ipv4 database")
+	} else {
+		return 0, false, errors.New("mmdb: invalid ip")
+	}
+
+	count := r.meta.NodeCount
+	for i := 0; i < len(bits)*8 && node < count; i++ {
+		bit := (bits[i>>3] >> (7 - uint(i)&7)) & 1
+		node = r.record(node, bit)
+	}
+	switch {
+	case node == count:
+		return 0, false, nil
+	case node > count:
+		offset := node - count - 16
+		if offset >= uint(len(r.data.buf)) {
+			return 0, false, ErrCorrupt
+		}
+		return offset, true, nil
+	}
+	return 0, false, ErrCorrupt
+}
+
+// record returns the left (bit 0) or the right (bit 1) record of the node
+func (r *Reader) record(node uint, bit byte) uint {
+	b := r.buf[node*r.nodeSize : (node+1)*r.nodeSize]
+	switch r.meta.RecordSize {
+	case 24:
+		if bit == 0 {
+			return uint(beUint(b[0:3]))
+		}
+		return uint(beUint(b[3:6]))
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(beUint(b[0:3]))
+		}
+		return uint(b[3]&0x0F)<<24 | uint(beUint(b[4:7]))
+	default:
+		if bit == 0 {
+			return uint(beUint(b[0:4]))
+		}
+		return uint(beUint(b[4:8]))
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mmdb_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP"
+	"github.com/Shivam010/go-freeGeoIP/mmdb"
+)
+
+const buildEpoch = 1600000000
+
+// cityRecord returns a GeoLite2-City like record
+func cityRecord(code, country, region, regionCode, city, zip, zone string, lat, long float64) map[string]interface{} {
+	return map[string]interface{}{
+		"city":    map[string]interface{}{"names": map[string]interface{}{"en": city, "de": city + " (de)"}},
+		"country": map[string]interface{}{"iso_code": code, "names": map[string]interface{}{"en": country}},
+		"location": map[string]interface{}{
+			"latitude":   lat,
+			"longitude":  long,
+			"time_zone":  zone,
+			"metro_code": uint16(0),
+		},
+		"postal": map[string]interface{}{"code": zip},
+		"subdivisions": []interface{}{
+			map[string]interface{}{"iso_code": regionCode, "names": map[string]interface{}{"en": region}},
+		},
+	}
+}
+
+// testDatabase writes the test database of the record size and ip version
+func testDatabase(recordSize, ipVersion int) []byte {
+	w := newTestWriter(recordSize, ipVersion)
+	w.insert("8.8.8.0/24", cityRecord("US", "United States", "California", "CA",
+		"Mountain View", "94043", "America/Los_Angeles", 37.386, -122.0838))
+	w.insert("1.1.1.1/32", map[string]interface{}{
+		"registered_country": map[string]interface{}{"iso_code": "AU", "names": map[string]interface{}{"en": "Australia"}},
+		"location":           map[string]interface{}{"latitude": float32(-33.5), "longitude": float32(151.5)},
+		"is_anycast":         true,
+		"accuracy":           int32(-1),
+		"long_string":        strings.Repeat("x", 300),
+	})
+	if ipVersion == 6 {
+		w.insert("2401:4900::/32", cityRecord("IN", "India", "Karnataka", "KA",
+			"Belgaum", "590006", "Asia/Kolkata", 15.8521, 74.5045))
+	}
+	return w.bytes(map[string]interface{}{
+		"database_type":               "GeoLite2-City",
+		"languages":                   []interface{}{"en", "de"},
+		"description":                 map[string]interface{}{"en": "test database"},
+		"build_epoch":                 uint64(buildEpoch),
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+	})
+}
+
+func TestReader(t *testing.T) {
+	for _, recordSize := range []int{24, 28, 32} {
+		for _, ipVersion := range []int{4, 6} {
+			r, err := mmdb.FromBytes(testDatabase(recordSize, ipVersion))
+			if err != nil {
+				t.Fatalf("FromBytes(%v, %v) error = %v, want no error", recordSize, ipVersion, err)
+			}
+			meta := r.Metadata()
+			if meta.DatabaseType != "GeoLite2-City" || meta.RecordSize != uint(recordSize) || meta.IPVersion != uint(ipVersion) {
+				t.Fatalf("Metadata() got = %+v", meta)
+			}
+			if !r.BuildTime().Equal(time.Unix(buildEpoch, 0)) {
+				t.Fatalf("BuildTime() got = %v, want %v", r.BuildTime(), time.Unix(buildEpoch, 0))
+			}
+
+			ctx := context.Background()
+			info, meta2, err := r.Lookup(ctx, freeGeoIP.ParseIP("8.8.8.8"))
+			if err != nil || meta2 != nil {
+				t.Fatalf("Lookup() got = %v, %v, want no error", meta2, err)
+			}
+			if info.IP.String() != "8.8.8.8" || info.CountryCode != "US" || info.CountryName != "United States" ||
+				info.RegionCode != "CA" || info.RegionName != "California" || info.City != "Mountain View" ||
+				info.ZipCode != "94043" || info.TimeZone.String() != "America/Los_Angeles" ||
+				info.Latitude != 37.386 || info.Longitude != -122.0838 {
+				t.Fatalf("Lookup() info got = %+v", info)
+			}
+
+			info, _, err = r.Lookup(ctx, freeGeoIP.ParseIP("1.1.1.1"))
+			if err != nil || info.CountryCode != "AU" || info.Latitude != -33.5 || info.City != "" {
+				t.Fatalf("Lookup() got = %+v, %v", info, err)
+			}
+			record, found, err := r.Record(net.ParseIP("1.1.1.1"))
+			if err != nil || !found {
+				t.Fatalf("Record() got = %v, %v", found, err)
+			}
+			m := record.(map[string]interface{})
+			if m["is_anycast"] != true || m["accuracy"] != int64(-1) || m["long_string"] != strings.Repeat("x", 300) {
+				t.Fatalf("Record() got = %+v", m)
+			}
+
+			if _, _, err = r.Lookup(ctx, freeGeoIP.ParseIP("8.8.4.4")); err != freeGeoIP.ErrNoResponse {
+				t.Fatalf("Lookup() error = %v, want %v", err, freeGeoIP.ErrNoResponse)
+			}
+			if _, _, err = r.Lookup(ctx, nil); err != freeGeoIP.ErrNoResponse {
+				t.Fatalf("Lookup() error = %v, want %v", err, freeGeoIP.ErrNoResponse)
+			}
+
+			info, _, err = r.Lookup(ctx, freeGeoIP.ParseIP("2401:4900:16ff:f1ef:fff5:f63e:8a25:a38a"))
+			if ipVersion == 4 && err == nil {
+				t.Fatalf("Lookup() of ipv6 in ipv4 database error = nil, want error")
+			}
+			if ipVersion == 6 && (err != nil || info.City != "Belgaum" || info.TimeZone.String() != "Asia/Kolkata") {
+				t.Fatalf("Lookup() got = %+v, %v", info, err)
+			}
+		}
+	}
+}
+
+func TestReaderLanguage(t *testing.T) {
+	r, err := mmdb.FromBytes(testDatabase(24, 6))
+	if err != nil {
+		t.Fatalf("FromBytes() error = %v, want no error", err)
+	}
+	r.Language = "de"
+	info, _, err := r.Lookup(context.Background(), freeGeoIP.ParseIP("8.8.8.8"))
+	if err != nil || info.City != "Mountain View (de)" || info.CountryName != "United States" {
+		t.Fatalf("Lookup() got = %+v, %v", info, err)
+	}
+}
+
+func TestOpenAndClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "GeoLite2-City.mmdb")
+	if err := ioutil.WriteFile(path, testDatabase(28, 6), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := mmdb.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v, want no error", err)
+	}
+	cli := &freeGeoIP.Client{Provider: r}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := cli.GetGeoInfo(context.Background(), freeGeoIP.ParseIP("8.8.8.8"))
+			if res.Error != nil || res.Info.City != "Mountain View" {
+				t.Errorf("GetGeoInfo() got = %+v", res)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCorruptDatabase(t *testing.T) {
+	db := testDatabase(24, 6)
+	if _, err := mmdb.FromBytes(db[:len(db)/2]); err == nil {
+		t.Fatalf("FromBytes() of truncated database error = nil, want error")
+	}
+	if _, err := mmdb.FromBytes([]byte("not a database")); err == nil {
+		t.Fatalf("FromBytes() of invalid database error = nil, want error")
+	}
+}
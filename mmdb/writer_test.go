@@ -0,0 +1,221 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mmdb_test
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"sort"
+)
+
+// testRecord is a search tree record of the testWriter
+type testRecord struct {
+	kind int // 0: empty, 1: node, 2: data
+	val  int
+}
+
+// testWriter writes the MaxMind DB files for the tests, it does not support
+// the overlapping networks
+type testWriter struct {
+	recordSize int
+	ipVersion  int
+	nodes      [][2]testRecord
+	data       bytes.Buffer
+	strings    map[string]int
+}
+
+func newTestWriter(recordSize, ipVersion int) *testWriter {
+	return &testWriter{
+		recordSize: recordSize,
+		ipVersion:  ipVersion,
+		nodes:      [][2]testRecord{{}},
+		strings:    map[string]int{},
+	}
+}
+
+// insert adds the value for the network in cidr notation
+func (w *testWriter) insert(cidr string, value interface{}) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	ones, bits := network.Mask.Size()
+	ip := []byte(network.IP.To16())
+	if bits == 32 {
+		if w.ipVersion == 4 {
+			ip = network.IP.To4()
+		} else {
+			ip, ones = append(make([]byte, 12), network.IP.To4()...), ones+96
+		}
+	}
+
+	offset := w.data.Len()
+	w.encode(&w.data, value, true)
+
+	node := 0
+	for i := 0; i < ones; i++ {
+		bit := (ip[i/8] >> (7 - uint(i)%8)) & 1
+		if i == ones-1 {
+			w.nodes[node][bit] = testRecord{kind: 2, val: offset}
+			break
+		}
+		if w.nodes[node][bit].kind != 1 {
+			w.nodes = append(w.nodes, [2]testRecord{})
+			w.nodes[node][bit] = testRecord{kind: 1, val: len(w.nodes) - 1}
+		}
+		node = w.nodes[node][bit].val
+	}
+}
+
+// bytes returns the complete database
+func (w *testWriter) bytes(metadata map[string]interface{}) []byte {
+	count := len(w.nodes)
+	out := &bytes.Buffer{}
+	for _, n := range w.nodes {
+		var rec [2]uint64
+		for i, r := range n {
+			switch r.kind {
+			case 0:
+				rec[i] = uint64(count)
+			case 1:
+				rec[i] = uint64(r.val)
+			case 2:
+				rec[i] = uint64(count + 16 + r.val)
+			}
+		}
+		switch w.recordSize {
+		case 24:
+			out.Write(be(rec[0], 3))
+			out.Write(be(rec[1], 3))
+		case 28:
+			l, r := be(rec[0], 4), be(rec[1], 4)
+			out.Write(l[1:])
+			out.WriteByte(l[0]<<4 | r[0]&0x0F)
+			out.Write(r[1:])
+		case 32:
+			out.Write(be(rec[0], 4))
+			out.Write(be(rec[1], 4))
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(w.data.Bytes())
+	out.WriteString("\xAB\xCD\xEFMaxMind.com")
+
+	metadata["node_count"] = uint64(count)
+	metadata["record_size"] = uint64(w.recordSize)
+	metadata["ip_version"] = uint64(w.ipVersion)
+	w.encode(out, metadata, false)
+	return out.Bytes()
+}
+
+// encode writes the value in the data section format, the repeated strings
+// are written as pointers, if pointers is true
+func (w *testWriter) encode(buf *bytes.Buffer, value interface{}, pointers bool) {
+	switch v := value.(type) {
+	case string:
+		if off, ok := w.strings[v]; ok && pointers {
+			switch {
+			case off < 2048:
+				buf.WriteByte(1<<5 | byte(off>>8))
+				buf.WriteByte(byte(off))
+			default:
+				off -= 2048
+				buf.WriteByte(1<<5 | 1<<3 | byte(off>>16))
+				buf.Write(be(uint64(off), 2))
+			}
+			return
+		}
+		if pointers && len(v) > 2 {
+			w.strings[v] = buf.Len()
+		}
+		ctrl(buf, 2, len(v))
+		buf.WriteString(v)
+	case float64:
+		ctrl(buf, 3, 8)
+		buf.Write(be(math.Float64bits(v), 8))
+	case float32:
+		ctrl(buf, 15, 4)
+		buf.Write(be(uint64(math.Float32bits(v)), 4))
+	case uint64:
+		n := 0
+		for x := v; x > 0; x >>= 8 {
+			n++
+		}
+		ctrl(buf, 9, n)
+		buf.Write(be(v, n))
+	case uint16:
+		ctrl(buf, 5, 2)
+		buf.Write(be(uint64(v), 2))
+	case int32:
+		ctrl(buf, 8, 4)
+		buf.Write(be(uint64(uint32(v)), 4))
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		ctrl(buf, 14, size)
+	case []interface{}:
+		ctrl(buf, 11, len(v))
+		for _, e := range v {
+			w.encode(buf, e, pointers)
+		}
+	case map[string]interface{}:
+		ctrl(buf, 7, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			w.encode(buf, k, pointers)
+			w.encode(buf, v[k], pointers)
+		}
+	default:
+		panic("unsupported type")
+	}
+}
+
+// ctrl writes the control byte(s) of the type and size
+func ctrl(buf *bytes.Buffer, typ, size int) {
+	var ext []byte
+	switch {
+	case size < 29:
+	case size < 285:
+		ext, size = be(uint64(size-29), 1), 29
+	case size < 65821:
+		ext, size = be(uint64(size-285), 2), 30
+	default:
+		ext, size = be(uint64(size-65821), 3), 31
+	}
+	if typ > 7 {
+		buf.WriteByte(byte(size))
+		buf.WriteByte(byte(typ - 7))
+	} else {
+		buf.WriteByte(byte(typ<<5 | size))
+	}
+	buf.Write(ext)
+}
+
+// be returns the n bytes big endian encoding of v
+func be(v uint64, n int) []byte {
+	b := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+	return b
+}
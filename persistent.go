@@ -0,0 +1,218 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultSnapshotInterval is the interval of the snapshots of the
+	// PersistentCache, used when the interval is not provided
+	DefaultSnapshotInterval = time.Minute
+
+	// _SnapshotVersion is the version of the snapshot file format
+	_SnapshotVersion = 1
+)
+
+// snapshotHeader is the first line of the snapshot file
+type snapshotHeader struct {
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+}
+
+// snapshotEntry is a line of the snapshot file, after the header, Expires is
+// in unix nanoseconds and zero if it never expires
+type snapshotEntry struct {
+	Key     string `json:"key"`
+	Expires int64  `json:"expires,omitempty"`
+	Info    *Info  `json:"info"`
+}
+
+// PersistentCache is the in-memory ICache, and IHostCache, which snapshots its
+// entries with their expiry to a file periodically, and reloads them when
+// opened again, so that it survives the restarts. The snapshots are written
+// to a temporary file first and then renamed over the previous snapshot, so
+// a crash never leaves a partial snapshot.
+// It must be closed with Close, which stops the periodic snapshots and takes
+// the final one.
+type PersistentCache struct {
+	*shardedCache
+
+	path     string
+	interval time.Duration
+	dirty    int32
+
+	mu        sync.Mutex
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// OpenPersistentCache opens the PersistentCache with its snapshot at path, if
+// the snapshot exists then its entries, which are not expired yet, are
+// loaded. The expiry and the expFn are same as of the NewCache, and the
+// snapshots are taken every interval, DefaultSnapshotInterval if not provided.
+func OpenPersistentCache(path string, expiry time.Duration, expFn CacheExpiryFunction, interval time.Duration) (*PersistentCache, error) {
+	if interval <= 0 {
+		interval = DefaultSnapshotInterval
+	}
+	c := &PersistentCache{
+		shardedCache: newShardedCache(expiry, expFn),
+		path:         path,
+		interval:     interval,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	go c.loop()
+	return c, nil
+}
+
+// Set saves the info in cache, see ICache
+func (c *PersistentCache) Set(ctx context.Context, info *Info) {
+	c.shardedCache.Set(ctx, info)
+	atomic.StoreInt32(&c.dirty, 1)
+}
+
+// SetHost saves the info in cache under the hostname, see IHostCache
+func (c *PersistentCache) SetHost(ctx context.Context, host string, info *Info) {
+	c.shardedCache.SetHost(ctx, host, info)
+	atomic.StoreInt32(&c.dirty, 1)
+}
+
+// Snapshot writes the snapshot of the entries not expired yet, it is also
+// called periodically and on Close
+func (c *PersistentCache) Snapshot() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	atomic.StoreInt32(&c.dirty, 0)
+	now := time.Now()
+	err := writeFileAtomic(c.path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		if err := enc.Encode(snapshotHeader{Version: _SnapshotVersion, Time: now}); err != nil {
+			return err
+		}
+		return c.each(now.UnixNano(), func(key string, entry cacheEntry) error {
+			return enc.Encode(snapshotEntry{Key: key, Expires: entry.expires, Info: entry.info})
+		})
+	})
+	if err != nil {
+		atomic.StoreInt32(&c.dirty, 1)
+		return wrapError("snapshot", err)
+	}
+	return nil
+}
+
+// Close stops the periodic snapshots and takes the final snapshot
+func (c *PersistentCache) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		<-c.done
+	})
+	return c.Snapshot()
+}
+
+// loop takes the snapshots every interval, if the cache has changed
+func (c *PersistentCache) loop() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			if atomic.LoadInt32(&c.dirty) == 1 {
+				_ = c.Snapshot()
+			}
+		}
+	}
+}
+
+// load loads the entries of the snapshot, not expired yet
+func (c *PersistentCache) load() error {
+	f, err := os.Open(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return wrapError("snapshot", err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(bufio.NewReader(f))
+	var header snapshotHeader
+	if err := dec.Decode(&header); err != nil {
+		return wrapError("snapshot", err)
+	}
+	if header.Version != _SnapshotVersion {
+		return wrapError("snapshot", errors.New("unsupported version"))
+	}
+	now := time.Now().UnixNano()
+	for {
+		var entry snapshotEntry
+		err := dec.Decode(&entry)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return wrapError("snapshot", err)
+		}
+		if entry.Info == nil || (entry.Expires != 0 && now >= entry.Expires) {
+			continue
+		}
+		c.restore(entry.Key, cacheEntry{info: entry.Info, expires: entry.Expires})
+	}
+}
+
+// writeFileAtomic writes the file at path with write, to a temporary file in
+// the same directory first, which is synced and renamed over the path
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,132 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP"
+)
+
+func TestPersistentCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freeGeoIP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.json")
+	expFn := func(ctx context.Context, ip freeGeoIP.IP) time.Duration {
+		if ip.String() == broadcastIP {
+			return 20 * time.Millisecond
+		}
+		return 0 // the expiry
+	}
+	ctx := context.Background()
+
+	cache, err := freeGeoIP.OpenPersistentCache(path, time.Hour, expFn, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenPersistentCache() error = %v, want no error", err)
+	}
+	cache.Set(ctx, response())
+	cache.Set(ctx, broadcastResponse())
+	cache.Set(ctx, &freeGeoIP.Info{CountryCode: "IN"}) // the ip of the caller
+	cache.SetHost(ctx, "example.com", response())
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Close() error = %v, want no error", err)
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	cache, err = freeGeoIP.OpenPersistentCache(path, time.Hour, expFn, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenPersistentCache() error = %v, want no error", err)
+	}
+	defer cache.Close()
+	if got, err := cache.Get(ctx, freeGeoIP.ParseIP(responseIP)); err != nil || compare(t, got, response()) {
+		t.Fatalf("cache.Get() error = %v, want no error", err)
+	}
+	if got, err := cache.Get(ctx, nil); err != nil || got.CountryCode != "IN" {
+		t.Fatalf("cache.Get() got = %v, %v", got, err)
+	}
+	if got, err := cache.GetHost(ctx, "example.com"); err != nil || got.City != "Belgaum" {
+		t.Fatalf("cache.GetHost() got = %v, %v", got, err)
+	}
+	// expired while the cache was closed
+	if _, err := cache.Get(ctx, freeGeoIP.ParseIP(broadcastIP)); err != freeGeoIP.ErrCacheMissed {
+		t.Fatalf("cache.Get() error = %v, want %v", err, freeGeoIP.ErrCacheMissed)
+	}
+}
+
+func TestPersistentCachePeriodicSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freeGeoIP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.json")
+
+	cache, err := freeGeoIP.OpenPersistentCache(path, freeGeoIP.NoCacheExpiration, nil, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("OpenPersistentCache() error = %v, want no error", err)
+	}
+	defer cache.Close()
+	cache.Set(context.Background(), response())
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("snapshot was not taken periodically")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// a crash now, without Close, still leaves a complete snapshot
+	other, err := freeGeoIP.OpenPersistentCache(path, freeGeoIP.NoCacheExpiration, nil, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenPersistentCache() error = %v, want no error", err)
+	}
+	defer other.Close()
+	if _, err := other.Get(context.Background(), freeGeoIP.ParseIP(responseIP)); err != nil {
+		t.Fatalf("cache.Get() error = %v, want no error", err)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("files got = %v, want only the snapshot", len(files))
+	}
+}
+
+func TestPersistentCacheCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freeGeoIP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.json")
+	for _, data := range []string{"not json", `{"version":99}`, `{"version":1}` + "\n" + `{"key":"8.8.8.8","info":`} {
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := freeGeoIP.OpenPersistentCache(path, time.Hour, nil, time.Hour); err == nil {
+			t.Fatalf("OpenPersistentCache(%q) error = nil, want error", data)
+		}
+	}
+}
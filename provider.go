@@ -0,0 +1,285 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Provider is the geolocation backend used by the Client to look up the ips.
+// Empty ip is the ip of the caller itself, if supported. If the information
+// is not found, then `ErrNoResponse` should be returned and the MetaInfo can
+// be nil, if the backend has no quota to report.
+// See it's default implementation: HTTPProvider
+type Provider interface {
+	Lookup(ctx context.Context, ip IP) (*Info, *MetaInfo, error)
+}
+
+// HostProvider is an optional interface for the Providers, which can look up
+// the hostnames directly, without resolving them locally
+type HostProvider interface {
+	LookupHost(ctx context.Context, host string) (*Info, *MetaInfo, error)
+}
+
+// HTTPProvider is the Provider for the freegeoip compatible HTTP APIs.
+// If HttpCli is not provided then will always use http.DefaultClient
+// And if Logger is not provided, then a noopLogger will be used
+// For the rest of the fields, see the Client object description
+type HTTPProvider struct {
+	HttpCli *http.Client
+	Logger  *log.Logger
+
+	BaseURL     string
+	URLTemplate string
+	Format      Format
+	APIKey      *APIKey
+	KeyPool     *KeyPool
+
+	Retry   *RetryPolicy
+	Limiter *RateLimiter
+}
+
+// provider returns the Provider of the Client, if not provided then the
+// HTTPProvider with the Client's configuration. The HybridProvider without a
+// Remote gets the HTTPProvider with the Client's configuration as its Remote.
+func (c *Client) provider() Provider {
+	if h, ok := c.Provider.(*HybridProvider); ok && h.Remote == nil {
+		hybrid := *h
+		hybrid.Remote = c.httpProvider()
+		return &hybrid
+	}
+	if c.Provider != nil {
+		return c.Provider
+	}
+	return c.httpProvider()
+}
+
+// httpProvider returns the HTTPProvider with the Client's configuration
+func (c *Client) httpProvider() *HTTPProvider {
+	return &HTTPProvider{
+		HttpCli:     c.HttpCli,
+		Logger:      c.Logger,
+		BaseURL:     c.BaseURL,
+		URLTemplate: c.URLTemplate,
+		Format:      c.Format,
+		APIKey:      c.APIKey,
+		KeyPool:     c.KeyPool,
+		Retry:       c.Retry,
+		Limiter:     c.Limiter,
+	}
+}
+
+// Lookup makes the http request to API for the ip
+func (p *HTTPProvider) Lookup(ctx context.Context, ip IP) (*Info, *MetaInfo, error) {
+	return p.do(ctx, ip.String())
+}
+
+// LookupHost makes the http request to API for the hostname
+func (p *HTTPProvider) LookupHost(ctx context.Context, host string) (*Info, *MetaInfo, error) {
+	return p.do(ctx, host)
+}
+
+// do is the internal method used to make the http request to API for the
+// query, an ip or a hostname, with the APIKey or the keys of the KeyPool
+func (p *HTTPProvider) do(ctx context.Context, query string) (*Info, *MetaInfo, error) {
+	if p.KeyPool == nil {
+		return p.request(ctx, query, p.APIKey)
+	}
+	var (
+		lastMeta *MetaInfo
+		lastErr  error
+	)
+	for {
+		i, key, meta, err := p.KeyPool.pick()
+		if err != nil {
+			if lastErr != nil {
+				return nil, lastMeta, lastErr
+			}
+			p.log("key pool error:", err)
+			return nil, meta, err
+		}
+		info, meta, err := p.request(ctx, query, key)
+		p.KeyPool.observe(i, meta, err)
+		if err != ErrLimitReached && err != ErrInvalidKey {
+			return info, meta, err
+		}
+		lastMeta, lastErr = meta, err
+	}
+}
+
+// request makes the http request to API for the query with the key
+func (p *HTTPProvider) request(ctx context.Context, query string, key *APIKey) (*Info, *MetaInfo, error) {
+	decoder, ok := decoders[p.format()]
+	if !ok {
+		p.log("unsupported format:", p.format())
+		return nil, nil, wrapError("decode", errors.New("unsupported format "+string(p.format())))
+	}
+
+	// http request
+	u, err := p.url(query)
+	if err != nil {
+		p.log("invalid request url:", err)
+		return nil, nil, wrapError("http", err)
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		p.log("http.NewRequest error:", err)
+		return nil, nil, wrapError("http", err)
+	}
+	key.apply(req)
+
+	// request's response, every attempt is rate limited
+	resp, err := p.roundTrip(ctx, req)
+	if err == ErrLimitReached {
+		return nil, nil, err
+	}
+	if err != nil {
+		p.log("http response error:", err)
+		return nil, nil, p.redactError("http", err)
+	}
+	defer resp.Body.Close()
+
+	// meta information
+	meta := extractMetaInfo(resp.Header)
+	meta.APIKey = key.Redacted()
+	p.limiter().observe(meta, resp.StatusCode == http.StatusForbidden)
+
+	// rate limit check
+	if resp.StatusCode == http.StatusForbidden {
+		p.log(ErrLimitReached)
+		return nil, meta, ErrLimitReached
+	}
+
+	// invalid api key
+	if resp.StatusCode == http.StatusUnauthorized {
+		p.log(ErrInvalidKey)
+		return nil, meta, ErrInvalidKey
+	}
+
+	// invalid ip
+	if resp.StatusCode == http.StatusNotFound {
+		p.log(ErrNoResponse)
+		return nil, meta, ErrNoResponse
+	}
+
+	// non ok status code
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(resp.Body)
+		p.log(ErrInternal, "status:", resp.Status, "response:", string(data))
+		return nil, meta, ErrInternal
+	}
+
+	// finally response
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		p.log("unreadable response body:", err)
+		return nil, meta, wrapError("response", err)
+	}
+
+	// decode
+	info, err := decoder(data)
+	return info, meta, err
+}
+
+// url builds the API request url for the query from the BaseURL and the
+// URLTemplate of the HTTPProvider
+func (p *HTTPProvider) url(query string) (string, error) {
+	base, tmpl := strings.TrimSuffix(p.BaseURL, "/"), p.URLTemplate
+	if base == "" {
+		base = DefaultBaseURL
+	}
+	if tmpl == "" {
+		tmpl = DefaultURLTemplate
+	}
+	escape := url.PathEscape
+	if q := strings.Index(tmpl, "?"); q >= 0 && q < strings.Index(tmpl, "{query}") {
+		escape = url.QueryEscape
+	}
+	raw := strings.NewReplacer(
+		"{base}", base,
+		"{format}", string(p.format()),
+		"{query}", escape(query),
+	).Replace(tmpl)
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// httpCli returns the http.Client of the HTTPProvider, http.DefaultClient if
+// not provided
+func (p *HTTPProvider) httpCli() *http.Client {
+	if p.HttpCli == nil {
+		return http.DefaultClient
+	}
+	return p.HttpCli
+}
+
+// limiter returns the Limiter of the HTTPProvider, the Limiter is not used
+// with the KeyPool, which tracks the quota of every key on its own
+func (p *HTTPProvider) limiter() *RateLimiter {
+	if p.KeyPool != nil {
+		return nil
+	}
+	return p.Limiter
+}
+
+// log prints to the Logger of the HTTPProvider, with the API keys redacted
+func (p *HTTPProvider) log(v ...interface{}) {
+	logger := p.Logger
+	if logger == nil {
+		logger = noopLogger
+	}
+	_ = logger.Output(2, p.redact(fmt.Sprintln(v...)))
+}
+
+// redactError returns err with the API keys redacted from its message
+func (p *HTTPProvider) redactError(pre string, err error) error {
+	e := wrapError(pre, err)
+	return _Error(p.redact(string(e)))
+}
+
+// redact replaces the API keys in s with their Redacted form
+func (p *HTTPProvider) redact(s string) string {
+	return p.KeyPool.redact(p.APIKey.redact(s))
+}
+
+// extractMetaInfo extract the meta details regarding the limit and reset timer
+// from the API response headers
+func extractMetaInfo(header http.Header) *MetaInfo {
+	atoi := func(key string) int64 {
+		v, _ := strconv.Atoi(header.Get(key))
+		return int64(v)
+	}
+	meta := &MetaInfo{
+		ResetIn:   time.Second * time.Duration(atoi(_HeaderResetIn)),
+		Limit:     atoi(_HeaderLimit),
+		Remaining: atoi(_HeaderRemaining),
+	}
+	if header.Get(_HeaderResetIn) != "" || header.Get(_HeaderRemaining) != "" {
+		meta.ResetAt = time.Now().Add(meta.ResetIn)
+	}
+	return meta
+}
@@ -0,0 +1,118 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/Shivam010/go-freeGeoIP"
+)
+
+// tableProvider is a Provider answering from a table of ip to Info
+type tableProvider struct {
+	mu    sync.Mutex
+	table map[string]*freeGeoIP.Info
+	meta  *freeGeoIP.MetaInfo
+	err   error
+	calls int
+}
+
+func (p *tableProvider) Lookup(_ context.Context, ip freeGeoIP.IP) (*freeGeoIP.Info, *freeGeoIP.MetaInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if p.err != nil {
+		return nil, p.meta, p.err
+	}
+	info, ok := p.table[ip.String()]
+	if !ok {
+		return nil, p.meta, freeGeoIP.ErrNoResponse
+	}
+	tmp := *info
+	return &tmp, p.meta, nil
+}
+
+func (p *tableProvider) Calls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+func TestCustomProvider(t *testing.T) {
+	provider := &tableProvider{
+		table: map[string]*freeGeoIP.Info{responseIP: response()},
+		meta:  &freeGeoIP.MetaInfo{Limit: 100, Remaining: 42},
+	}
+	cli := &freeGeoIP.Client{Cache: freeGeoIP.DefaultCache(), Provider: provider}
+	ctx := context.Background()
+
+	res := cli.GetGeoInfoFromString(ctx, responseIP)
+	if res.Error != nil {
+		t.Fatalf("GetGeoInfoFromString() error = %v, want no error", res.Error)
+	}
+	if res.Cached || res.Meta.Remaining != 42 {
+		t.Fatalf("GetGeoInfoFromString() got = %+v, want uncached response with provider meta", res)
+	}
+	compare(t, res.Info, response())
+
+	sec := cli.GetGeoInfoFromString(ctx, responseIP)
+	if !sec.Cached {
+		t.Fatalf("GetGeoInfoFromString() for second call output must be cached")
+	}
+	compare(t, sec.Info, response())
+
+	if res := cli.GetGeoInfoFromString(ctx, dnsIP); res.Error != freeGeoIP.ErrNoResponse {
+		t.Fatalf("GetGeoInfoFromString() error = %v, want %v", res.Error, freeGeoIP.ErrNoResponse)
+	}
+
+	// provider without host lookup support, hostname is resolved locally
+	cli.Resolver = fakeResolver{responseIP}
+	if res := cli.GetGeoInfoForHost(ctx, "example.com"); res.Error != nil || !res.Cached {
+		t.Fatalf("GetGeoInfoForHost() got = %+v, want cached response of resolved ip", res)
+	}
+	if calls := provider.Calls(); calls != 2 {
+		t.Fatalf("Lookup() calls got = %v, want %v", calls, 2)
+	}
+}
+
+func TestHTTPProvider(t *testing.T) {
+	var gotURI string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURI = r.URL.RequestURI()
+		_, _ = w.Write([]byte("8.8.8.8,US,United States,,,,,America/Chicago,37.751,-97.822,0\r\n"))
+	}))
+	defer srv.Close()
+
+	provider := &freeGeoIP.HTTPProvider{BaseURL: srv.URL, Format: freeGeoIP.FormatCSV}
+	info, meta, err := provider.Lookup(context.Background(), freeGeoIP.ParseIP(dnsIP))
+	if err != nil {
+		t.Fatalf("Lookup() error = %v, want no error", err)
+	}
+	if meta == nil || info.CountryCode != "US" || info.TimeZone.String() != "America/Chicago" {
+		t.Fatalf("Lookup() got = %+v, %+v", info, meta)
+	}
+
+	cli := &freeGeoIP.Client{Provider: provider}
+	if res := cli.GetGeoInfoFromString(context.Background(), "example.com"); res.Error != nil {
+		t.Fatalf("GetGeoInfoFromString() error = %v, want no error", res.Error)
+	}
+	if gotURI != "/csv/example.com" {
+		t.Fatalf("request uri got = %v, want %v", gotURI, "/csv/example.com")
+	}
+}
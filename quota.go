@@ -0,0 +1,96 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultQuotaLimit is the assumed API limit, until a response with the
+	// rate limit headers is received
+	DefaultQuotaLimit = 15000
+	// DefaultQuotaWindow is the assumed duration after which the API limit
+	// resets again, once a known reset time has passed
+	DefaultQuotaWindow = time.Hour
+)
+
+// QuotaTracker tracks the API quota from the rate limit headers of the API
+// responses. Its zero value is ready to use and it can be shared by multiple
+// Clients using the same quota.
+type QuotaTracker struct {
+	mu        sync.Mutex
+	known     bool
+	limit     int64
+	remaining int64
+	resetAt   time.Time
+}
+
+// update records the quota from the meta information of an API response, the
+// responses without rate limit headers are ignored
+func (q *QuotaTracker) update(meta *MetaInfo) {
+	if meta == nil || meta.ResetAt.IsZero() {
+		return
+	}
+	q.mu.Lock()
+	q.known = true
+	q.limit = meta.Limit
+	q.remaining = meta.Remaining
+	q.resetAt = meta.ResetAt
+	q.mu.Unlock()
+}
+
+// Snapshot returns the current quota, accounting for the time passed since the
+// last response, i.e. if the reset time has passed then the whole limit is
+// considered remaining
+func (q *QuotaTracker) Snapshot() MetaInfo {
+	now := time.Now()
+	q.mu.Lock()
+	meta := MetaInfo{
+		ResetAt:   q.resetAt,
+		Limit:     q.limit,
+		Remaining: q.remaining,
+	}
+	known := q.known
+	q.mu.Unlock()
+
+	if !known {
+		meta.Limit = DefaultQuotaLimit
+		meta.Remaining = DefaultQuotaLimit
+		meta.ResetAt = now.Add(DefaultQuotaWindow)
+	}
+	if !meta.ResetAt.After(now) {
+		meta.Remaining = meta.Limit
+		for !meta.ResetAt.After(now) {
+			meta.ResetAt = meta.ResetAt.Add(DefaultQuotaWindow)
+		}
+	}
+	meta.ResetIn = meta.ResetAt.Sub(now)
+	return meta
+}
+
+// Quota returns the current API quota of the Client, see QuotaTracker.Snapshot
+func (c *Client) Quota() MetaInfo {
+	return c.tracker().Snapshot()
+}
+
+// tracker returns the QuotaTracker of the Client, its own if not provided
+func (c *Client) tracker() *QuotaTracker {
+	if c.QuotaTracker != nil {
+		return c.QuotaTracker
+	}
+	return &c.quota
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP"
+)
+
+func TestClientQuota(t *testing.T) {
+	var firstCalls, secondCalls int32
+	first, second := quotaServer(5, &firstCalls), quotaServer(100, &secondCalls)
+	defer first.Close()
+	defer second.Close()
+
+	ctx := context.Background()
+	cli := &freeGeoIP.Client{BaseURL: first.URL, Cache: freeGeoIP.DefaultCache()}
+	other := &freeGeoIP.Client{BaseURL: second.URL}
+
+	if quota := cli.Quota(); quota.Limit != freeGeoIP.DefaultQuotaLimit {
+		t.Fatalf("Quota() limit before any call got = %v, want %v", quota.Limit, freeGeoIP.DefaultQuotaLimit)
+	}
+	if res := cli.GetGeoInfoFromString(ctx, dnsIP); res.Meta.Remaining != 4 {
+		t.Fatalf("GetGeoInfoFromString() remaining got = %v, want %v", res.Meta.Remaining, 4)
+	}
+	if res := other.GetGeoInfoFromString(ctx, dnsIP); res.Meta.Remaining != 99 {
+		t.Fatalf("GetGeoInfoFromString() remaining got = %v, want %v", res.Meta.Remaining, 99)
+	}
+
+	// clients must not overwrite each other's quota
+	quota := cli.Quota()
+	if quota.Limit != 5 || quota.Remaining != 4 {
+		t.Fatalf("Quota() got = %+v, want limit %v and remaining %v", quota, 5, 4)
+	}
+	if quota.ResetIn <= 0 || quota.ResetIn > time.Second {
+		t.Fatalf("Quota() reset in got = %v, want in (0, 1s]", quota.ResetIn)
+	}
+
+	// cached response must report the client's quota
+	res := cli.GetGeoInfoFromString(ctx, dnsIP)
+	if !res.Cached || res.Meta.Limit != 5 || res.Meta.Remaining != 4 {
+		t.Fatalf("GetGeoInfoFromString() for cached call got = %+v, want limit %v and remaining %v", res.Meta, 5, 4)
+	}
+
+	// once reset time passes, the whole limit is remaining
+	time.Sleep(quota.ResetIn + 10*time.Millisecond)
+	quota = cli.Quota()
+	if quota.Remaining != 5 || !quota.ResetAt.After(time.Now()) {
+		t.Fatalf("Quota() after reset got = %+v, want remaining %v", quota, 5)
+	}
+}
+
+func TestSharedQuotaTracker(t *testing.T) {
+	var calls int32
+	srv := quotaServer(10, &calls)
+	defer srv.Close()
+
+	tracker := &freeGeoIP.QuotaTracker{}
+	cli := &freeGeoIP.Client{BaseURL: srv.URL, QuotaTracker: tracker}
+	other := &freeGeoIP.Client{BaseURL: srv.URL, QuotaTracker: tracker}
+
+	cli.GetGeoInfoFromString(context.Background(), dnsIP)
+	other.GetGeoInfoFromString(context.Background(), dnsIP)
+	if quota := cli.Quota(); quota.Remaining != 8 {
+		t.Fatalf("Quota() remaining got = %v, want %v", quota.Remaining, 8)
+	}
+	if quota := tracker.Snapshot(); quota.Remaining != 8 {
+		t.Fatalf("Snapshot() remaining got = %v, want %v", quota.Remaining, 8)
+	}
+}
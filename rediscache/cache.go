@@ -0,0 +1,309 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rediscache is the freeGeoIP.ICache backed by a redis server, to
+// share the looked up information across a fleet. It speaks the redis
+// protocol (RESP) with a minimal built-in client, so it adds no dependency.
+// The entries are stored as json with the native TTLs of redis, under a key
+// prefix and an optional namespace, over a pool of connections.
+package rediscache
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP"
+	"github.com/Shivam010/go-freeGeoIP/internal/cacheutil"
+)
+
+const (
+	// DefaultAddr is the address of the redis server, used when Options.Addr
+	// is not provided
+	DefaultAddr = "localhost:6379"
+	// DefaultPrefix is the prefix of the keys, used when Options.Prefix is not
+	// provided
+	DefaultPrefix = "freegeoip"
+	// DefaultPoolSize is the maximum number of connections, used when
+	// Options.PoolSize is not provided
+	DefaultPoolSize = 10
+	// DefaultTimeout is the timeout of dialing and of every round trip, used
+	// when Options.Timeout is not provided
+	DefaultTimeout = time.Second
+
+	ipKind = "ip:"
+)
+
+// Options are the options of the Cache.
+// Username and Password are used to authenticate, and DB is selected on every
+// new connection, if provided.
+// The keys are "<Prefix>:<Namespace>:ip:<ip>" and
+// "<Prefix>:<Namespace>:host:<hostname>", the Namespace is left out if not
+// provided, so that many deployments or datasets can share a redis server.
+// Expiry and ExpiryFn are same as the expiry and the expFn of the
+// freeGeoIP.NewCache, and are set as the TTLs of the keys, if the Expiry is
+// not provided the entries never expire.
+// If Dial is not provided, the net.Dialer is used, and if Logger is not
+// provided, the failures of Set and SetHost are not logged.
+type Options struct {
+	Addr     string
+	Username string
+	Password string
+	DB       int
+
+	Prefix    string
+	Namespace string
+
+	Expiry   time.Duration
+	ExpiryFn freeGeoIP.CacheExpiryFunction
+
+	PoolSize int
+	Timeout  time.Duration
+	Dial     func(ctx context.Context, network, addr string) (net.Conn, error)
+	Logger   *log.Logger
+}
+
+// Cache is the freeGeoIP.ICache, freeGeoIP.IHostCache and
+// freeGeoIP.IBatchCache backed by a redis server. It is safe for the
+// concurrent use and should be closed with Close.
+type Cache struct {
+	opts   Options
+	expiry cacheutil.Expiry
+	prefix string
+	pool   *pool
+}
+
+// New returns the Cache for the options, the connections are dialed lazily
+func New(opts Options) *Cache {
+	if opts.Addr == "" {
+		opts.Addr = DefaultAddr
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultPrefix
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = DefaultPoolSize
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.Dial == nil {
+		opts.Dial = (&net.Dialer{}).DialContext
+	}
+	if opts.Logger == nil {
+		opts.Logger = log.New(ioutil.Discard, "", 0)
+	}
+	c := &Cache{opts: opts, prefix: opts.Prefix + ":"}
+	if opts.Namespace != "" {
+		c.prefix += opts.Namespace + ":"
+	}
+	c.expiry.Expiry = opts.Expiry
+	if fn := opts.ExpiryFn; fn != nil {
+		c.expiry.ExpiryFn = func(ctx context.Context, ip net.IP) time.Duration {
+			return fn(ctx, freeGeoIP.IP(ip))
+		}
+	}
+	c.pool = newPool(opts.PoolSize, c.dial)
+	return c
+}
+
+// Set stores the info under its ip, with the TTL as per its expiry
+func (c *Cache) Set(ctx context.Context, info *freeGeoIP.Info) {
+	if info == nil {
+		return
+	}
+	c.set(ctx, c.prefix+ipKind+info.IP.String(), info)
+}
+
+// Get returns the cached ip info, if not found or expired then a cache missed
+// error, `freeGeoIP.ErrCacheMissed` will be returned
+// The error will also be returned when explicit cache miss is requested
+func (c *Cache) Get(ctx context.Context, ip freeGeoIP.IP) (*freeGeoIP.Info, error) {
+	if c.expiry.Skip(ctx, net.IP(ip)) {
+		return nil, freeGeoIP.ErrCacheMissed
+	}
+	replies, err := c.do(ctx, []string{"GET", c.prefix + ipKind + ip.String()})
+	if err != nil {
+		return nil, err
+	}
+	return decode(replies[0])
+}
+
+// GetBatch returns the cached information of all the ips with a single
+// pipelined round trip, nil for the ones not found, see freeGeoIP.IBatchCache
+func (c *Cache) GetBatch(ctx context.Context, ips []freeGeoIP.IP) ([]*freeGeoIP.Info, error) {
+	infos := make([]*freeGeoIP.Info, len(ips))
+	var (
+		cmds      [][]string
+		positions []int
+	)
+	for i, ip := range ips {
+		if !c.expiry.Skip(ctx, net.IP(ip)) {
+			cmds = append(cmds, []string{"GET", c.prefix + ipKind + ip.String()})
+			positions = append(positions, i)
+		}
+	}
+	if len(cmds) == 0 {
+		return infos, nil
+	}
+	replies, err := c.do(ctx, cmds...)
+	if err != nil {
+		return nil, err
+	}
+	for i, reply := range replies {
+		infos[positions[i]], _ = decode(reply)
+	}
+	return infos, nil
+}
+
+// SetHost stores the info under the hostname, with the TTL as per the expiry
+// of the info's ip
+func (c *Cache) SetHost(ctx context.Context, host string, info *freeGeoIP.Info) {
+	if info == nil {
+		return
+	}
+	c.set(ctx, c.prefix+cacheutil.HostKey(host), info)
+}
+
+// GetHost returns the cached hostname info, if not found or expired then a
+// cache missed error, `freeGeoIP.ErrCacheMissed` will be returned
+// The hostname's ip is only known once its key is fetched, so the error is
+// also returned, after the round trip, if the ip is explicitly denied
+func (c *Cache) GetHost(ctx context.Context, host string) (*freeGeoIP.Info, error) {
+	replies, err := c.do(ctx, []string{"GET", c.prefix + cacheutil.HostKey(host)})
+	if err != nil {
+		return nil, err
+	}
+	info, err := decode(replies[0])
+	if err != nil || c.expiry.Skip(ctx, net.IP(info.IP)) {
+		return nil, freeGeoIP.ErrCacheMissed
+	}
+	return info, nil
+}
+
+// Close closes the connections to the redis server
+func (c *Cache) Close() error {
+	return c.pool.close()
+}
+
+func (c *Cache) set(ctx context.Context, key string, info *freeGeoIP.Info) {
+	now := time.Now()
+	at, ok := c.expiry.At(ctx, net.IP(info.IP), now)
+	if !ok {
+		return
+	}
+	value, err := json.Marshal(info)
+	if err != nil {
+		c.opts.Logger.Println("rediscache: set error:", err)
+		return
+	}
+	cmd := []string{"SET", key, string(value)}
+	if !at.IsZero() {
+		ttl := at.Sub(now) / time.Millisecond
+		if ttl < 1 {
+			ttl = 1
+		}
+		cmd = append(cmd, "PX", strconv.FormatInt(int64(ttl), 10))
+	}
+	replies, err := c.do(ctx, cmd)
+	if err == nil {
+		if e, ok := replies[0].(Error); ok {
+			err = e
+		}
+	}
+	if err != nil {
+		c.opts.Logger.Println("rediscache: set error:", err)
+	}
+}
+
+// do sends the commands to the redis server in a single round trip, and
+// returns their replies. The round trip must complete within the Timeout, or
+// the deadline of the ctx, whichever is earlier.
+func (c *Cache) do(ctx context.Context, cmds ...[]string) ([]interface{}, error) {
+	deadline := time.Now().Add(c.opts.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	for {
+		cn, reused, err := c.pool.get(ctx)
+		if err != nil {
+			return nil, err
+		}
+		replies, err := cn.pipeline(deadline, cmds)
+		c.pool.put(cn, err != nil)
+		// the idle connection may have been closed by the server, the commands
+		// are then sent again on another one, they are all idempotent
+		if err != nil && reused && ctx.Err() == nil && time.Now().Before(deadline) {
+			continue
+		}
+		return replies, err
+	}
+}
+
+// dial opens a new connection, authenticated and with the DB selected
+func (c *Cache) dial(ctx context.Context) (*conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+	nc, err := c.opts.Dial(ctx, "tcp", c.opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	cn := newConn(nc)
+	var cmds [][]string
+	if c.opts.Password != "" {
+		auth := []string{"AUTH", c.opts.Password}
+		if c.opts.Username != "" {
+			auth = []string{"AUTH", c.opts.Username, c.opts.Password}
+		}
+		cmds = append(cmds, auth)
+	}
+	if c.opts.DB != 0 {
+		cmds = append(cmds, []string{"SELECT", strconv.Itoa(c.opts.DB)})
+	}
+	if len(cmds) == 0 {
+		return cn, nil
+	}
+	deadline, _ := ctx.Deadline()
+	replies, err := cn.pipeline(deadline, cmds)
+	for i := 0; err == nil && i < len(replies); i++ {
+		if e, ok := replies[i].(Error); ok {
+			err = e
+		}
+	}
+	if err != nil {
+		_ = nc.Close()
+		return nil, err
+	}
+	return cn, nil
+}
+
+// decode returns the info of the GET reply, a cache missed error if it is
+// not found or can not be decoded
+func decode(reply interface{}) (*freeGeoIP.Info, error) {
+	switch v := reply.(type) {
+	case Error:
+		return nil, v
+	case []byte:
+		info := &freeGeoIP.Info{}
+		if err := json.Unmarshal(v, info); err != nil {
+			return nil, freeGeoIP.ErrCacheMissed
+		}
+		return info, nil
+	}
+	return nil, freeGeoIP.ErrCacheMissed
+}
@@ -0,0 +1,264 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rediscache_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP"
+	"github.com/Shivam010/go-freeGeoIP/rediscache"
+	"github.com/Shivam010/go-freeGeoIP/rediscache/redistest"
+)
+
+// ipInfo returns the Info of the ith test ip
+func ipInfo(i int) *freeGeoIP.Info {
+	zone, _ := time.LoadLocation("Asia/Kolkata")
+	return &freeGeoIP.Info{
+		IP:       freeGeoIP.ParseIP("10.0." + strconv.Itoa(i/256) + "." + strconv.Itoa(i%256)),
+		City:     "Belgaum",
+		TimeZone: freeGeoIP.LocationF(zone),
+		Latitude: 15.8521,
+	}
+}
+
+func TestCache(t *testing.T) {
+	srv := redistest.NewServer()
+	defer srv.Close()
+	cache := rediscache.New(rediscache.Options{
+		Addr:      srv.Addr,
+		Namespace: "test",
+		Expiry:    time.Hour,
+		ExpiryFn: func(ctx context.Context, ip freeGeoIP.IP) time.Duration {
+			switch ip.String() {
+			case "10.0.0.1":
+				return freeGeoIP.SkipCache
+			case "10.0.0.2":
+				return time.Minute
+			case "10.0.0.3":
+				return freeGeoIP.NoCacheExpiration
+			}
+			return 0 // the Expiry
+		},
+	})
+	defer cache.Close()
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		cache.Set(ctx, ipInfo(i))
+	}
+	cache.Set(ctx, &freeGeoIP.Info{CountryCode: "IN"}) // the ip of the caller
+	cache.SetHost(ctx, "example.com", ipInfo(0))
+
+	want := []string{
+		"freegeoip:test:host:example.com",
+		"freegeoip:test:ip:",
+		"freegeoip:test:ip:10.0.0.0",
+		"freegeoip:test:ip:10.0.0.2",
+		"freegeoip:test:ip:10.0.0.3",
+	}
+	if keys := srv.Keys(0); lines(keys) != lines(want) {
+		t.Fatalf("Keys() got = %v, want %v", keys, want)
+	}
+	for key, want := range map[string]time.Duration{
+		"freegeoip:test:ip:10.0.0.0": time.Hour,
+		"freegeoip:test:ip:10.0.0.2": time.Minute,
+		"freegeoip:test:ip:10.0.0.3": 0,
+	} {
+		if ttl, _ := srv.TTL(0, key); ttl > want || ttl < want-time.Second {
+			t.Fatalf("TTL(%v) got = %v, want %v", key, ttl, want)
+		}
+	}
+
+	got, err := cache.Get(ctx, ipInfo(0).IP)
+	if err != nil || got.City != "Belgaum" || got.TimeZone.String() != "Asia/Kolkata" || got.Latitude != 15.8521 {
+		t.Fatalf("Get() got = %+v, %v", got, err)
+	}
+	if got, err := cache.Get(ctx, nil); err != nil || got.CountryCode != "IN" {
+		t.Fatalf("Get() got = %+v, %v", got, err)
+	}
+	if got, err := cache.GetHost(ctx, "example.com"); err != nil || got.City != "Belgaum" {
+		t.Fatalf("GetHost() got = %+v, %v", got, err)
+	}
+	if _, err := cache.Get(ctx, ipInfo(1).IP); err != freeGeoIP.ErrCacheMissed {
+		t.Fatalf("Get() error = %v, want %v", err, freeGeoIP.ErrCacheMissed)
+	}
+	if _, err := cache.GetHost(ctx, "example.org"); err != freeGeoIP.ErrCacheMissed {
+		t.Fatalf("GetHost() error = %v, want %v", err, freeGeoIP.ErrCacheMissed)
+	}
+
+	srv.FastForward(2 * time.Minute)
+	if _, err := cache.Get(ctx, ipInfo(2).IP); err != freeGeoIP.ErrCacheMissed {
+		t.Fatalf("Get() of expired error = %v, want %v", err, freeGeoIP.ErrCacheMissed)
+	}
+	if _, err := cache.Get(ctx, ipInfo(3).IP); err != nil {
+		t.Fatalf("Get() of non expiring error = %v, want no error", err)
+	}
+}
+
+// lines joins the keys for the comparison
+func lines(keys []string) string {
+	s := ""
+	for _, key := range keys {
+		s += key + "\n"
+	}
+	return s
+}
+
+func TestCacheGetBatch(t *testing.T) {
+	srv := redistest.NewServer()
+	defer srv.Close()
+	cache := rediscache.New(rediscache.Options{
+		Addr: srv.Addr,
+		ExpiryFn: func(ctx context.Context, ip freeGeoIP.IP) time.Duration {
+			if ip.String() == "10.0.0.99" {
+				return freeGeoIP.SkipCache
+			}
+			return freeGeoIP.NoCacheExpiration
+		},
+	})
+	defer cache.Close()
+	ctx := context.Background()
+
+	var ips []freeGeoIP.IP
+	for i := 0; i < 100; i++ {
+		if i%2 == 0 {
+			cache.Set(ctx, ipInfo(i))
+		}
+		ips = append(ips, ipInfo(i).IP)
+	}
+	commands := srv.Commands()
+	infos, err := cache.GetBatch(ctx, ips)
+	if err != nil {
+		t.Fatalf("GetBatch() error = %v, want no error", err)
+	}
+	for i, info := range infos {
+		if (info != nil) != (i%2 == 0) {
+			t.Fatalf("GetBatch()[%v] got = %+v", i, info)
+		}
+		if info != nil && info.IP.String() != ips[i].String() {
+			t.Fatalf("GetBatch()[%v] ip got = %v, want %v", i, info.IP, ips[i])
+		}
+	}
+	// the skipped ip is not requested, and all of them share a connection
+	if got := srv.Commands() - commands; got != 99 {
+		t.Fatalf("GetBatch() commands got = %v, want %v", got, 99)
+	}
+	if srv.Connections() != 1 {
+		t.Fatalf("Connections() got = %v, want %v", srv.Connections(), 1)
+	}
+
+	// the Client looks up the batch with the pipelined GetBatch
+	cli := &freeGeoIP.Client{Cache: cache, Provider: belgaum{}}
+	commands = srv.Commands()
+	for i, res := range cli.GetGeoInfoBatch(ctx, ips[:10], nil) {
+		if res.Error != nil || res.Cached != (i%2 == 0) {
+			t.Fatalf("GetGeoInfoBatch()[%v] got = %+v", i, res)
+		}
+	}
+	// a GET for each ip, and a SET for each miss
+	if got := srv.Commands() - commands; got != 15 {
+		t.Fatalf("GetGeoInfoBatch() commands got = %v, want %v", got, 15)
+	}
+}
+
+// belgaum is the Provider which locates every ip in Belgaum
+type belgaum struct{}
+
+func (belgaum) Lookup(ctx context.Context, ip freeGeoIP.IP) (*freeGeoIP.Info, *freeGeoIP.MetaInfo, error) {
+	info := ipInfo(0)
+	info.IP = ip
+	return info, nil, nil
+}
+
+func TestCachePool(t *testing.T) {
+	srv := redistest.NewServer()
+	defer srv.Close()
+	cache := rediscache.New(rediscache.Options{Addr: srv.Addr, PoolSize: 4})
+	defer cache.Close()
+	ctx := context.Background()
+	cache.Set(ctx, ipInfo(0))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cache.Set(ctx, ipInfo(i))
+			if got, err := cache.Get(ctx, ipInfo(0).IP); err != nil || got.City != "Belgaum" {
+				t.Errorf("Get() got = %+v, %v", got, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if srv.Connections() > 4 {
+		t.Fatalf("Connections() got = %v, want at most %v", srv.Connections(), 4)
+	}
+
+	// the connections closed by the server are replaced
+	srv.CloseConnections()
+	if _, err := cache.Get(ctx, ipInfo(0).IP); err != nil {
+		t.Fatalf("Get() after the connections are closed error = %v, want no error", err)
+	}
+
+	if err := cache.Close(); err != nil {
+		t.Fatalf("Close() error = %v, want no error", err)
+	}
+	if _, err := cache.Get(ctx, ipInfo(0).IP); err != rediscache.ErrClosed {
+		t.Fatalf("Get() after Close error = %v, want %v", err, rediscache.ErrClosed)
+	}
+}
+
+func TestCacheAuth(t *testing.T) {
+	srv := redistest.NewServer()
+	defer srv.Close()
+	srv.RequirePass("secret")
+	ctx := context.Background()
+
+	cache := rediscache.New(rediscache.Options{Addr: srv.Addr, Password: "wrong"})
+	defer cache.Close()
+	if _, err := cache.Get(ctx, ipInfo(0).IP); err == nil || err == freeGeoIP.ErrCacheMissed {
+		t.Fatalf("Get() with wrong password error = %v, want auth error", err)
+	}
+
+	cache = rediscache.New(rediscache.Options{Addr: srv.Addr, Username: "default", Password: "secret", DB: 2})
+	defer cache.Close()
+	cache.Set(ctx, ipInfo(0))
+	if _, err := cache.Get(ctx, ipInfo(0).IP); err != nil {
+		t.Fatalf("Get() error = %v, want no error", err)
+	}
+	if len(srv.Keys(0)) != 0 || len(srv.Keys(2)) != 1 {
+		t.Fatalf("Keys() got = %v and %v, want the key in db 2", srv.Keys(0), srv.Keys(2))
+	}
+}
+
+func TestCacheTimeout(t *testing.T) {
+	ctx := context.Background()
+	// nothing is listening on the port of the closed server
+	srv := redistest.NewServer()
+	srv.Close()
+	cache := rediscache.New(rediscache.Options{Addr: srv.Addr, Timeout: 100 * time.Millisecond})
+	defer cache.Close()
+	if _, err := cache.Get(ctx, ipInfo(0).IP); err == nil || err == freeGeoIP.ErrCacheMissed {
+		t.Fatalf("Get() error = %v, want dial error", err)
+	}
+	if _, err := cache.GetBatch(ctx, []freeGeoIP.IP{ipInfo(0).IP}); err == nil {
+		t.Fatalf("GetBatch() error = nil, want dial error")
+	}
+	cache.Set(ctx, ipInfo(0)) // must not panic
+}
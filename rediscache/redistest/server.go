@@ -0,0 +1,433 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package redistest provides an in-process stand-in of the redis server, for
+// testing the rediscache.Cache without a redis deployment. It speaks enough of
+// the redis protocol (RESP) for the cache: PING, AUTH, SELECT, GET, SET with
+// the EX and PX options, DEL, PTTL, FLUSHDB and QUIT.
+//
+//	srv := redistest.NewServer()
+//	defer srv.Close()
+//	cache := rediscache.New(rediscache.Options{Addr: srv.Addr})
+package redistest
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errProtocol is the malformed command of a client
+var errProtocol = errors.New("protocol error")
+
+// entry is a value of the Server, expires is zero if it never expires
+type entry struct {
+	value   string
+	expires time.Time
+}
+
+// Server is the stand-in redis server, storing the strings in the memory of
+// the process, in separate databases selected with SELECT.
+// The Addr is the address the Server is listening on.
+type Server struct {
+	Addr string
+
+	ln net.Listener
+	wg sync.WaitGroup
+
+	mu          sync.Mutex
+	pass        string
+	dbs         map[int]map[string]entry
+	offset      time.Duration
+	conns       map[net.Conn]struct{}
+	connections int
+	commands    int
+	closed      bool
+}
+
+// NewServer starts and returns a new Server listening on a local port. The
+// caller should call Close when finished, to shut it down.
+func NewServer() *Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic("redistest: failed to listen on a port: " + err.Error())
+	}
+	s := &Server{
+		Addr:  ln.Addr().String(),
+		ln:    ln,
+		dbs:   map[int]map[string]entry{},
+		conns: map[net.Conn]struct{}{},
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s
+}
+
+// Close shuts down the Server, closing all of its connections
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	_ = s.ln.Close()
+	s.CloseConnections()
+	s.wg.Wait()
+}
+
+// RequirePass sets the password, which the new connections must AUTH with,
+// with any username, before any other command. Empty password disables the
+// authentication.
+func (s *Server) RequirePass(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pass = password
+}
+
+// CloseConnections closes all of the open connections, like the redis server
+// closing the idle clients
+func (s *Server) CloseConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		_ = c.Close()
+	}
+}
+
+// FastForward moves the clock of the Server by d, expiring the keys due
+func (s *Server) FastForward(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset += d
+}
+
+// Keys returns the sorted keys of the db, which are not expired
+func (s *Server) Keys(db int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	var keys []string
+	for key, e := range s.dbs[db] {
+		if !e.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Get returns the value of the key in the db, and reports whether it exists
+func (s *Server) Get(db int, key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.get(db, key)
+	return e.value, ok
+}
+
+// TTL returns the remaining time to live of the key in the db, zero if it
+// never expires, and reports whether the key exists
+func (s *Server) TTL(db int, key string) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.get(db, key)
+	if !ok || e.expires.IsZero() {
+		return 0, ok
+	}
+	return e.expires.Sub(s.now()), true
+}
+
+// Connections returns the number of the connections accepted so far
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connections
+}
+
+// Commands returns the number of the commands served so far
+func (s *Server) Commands() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.commands
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = c.Close()
+			return
+		}
+		s.conns[c] = struct{}{}
+		s.connections++
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.serve(c)
+	}
+}
+
+// serve serves the commands of the connection, the replies are flushed once
+// all of the pipelined commands read are served
+func (s *Server) serve(c net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		_ = c.Close()
+	}()
+	r, w := bufio.NewReader(c), bufio.NewWriter(c)
+	s.mu.Lock()
+	sess := &session{authed: s.pass == ""}
+	s.mu.Unlock()
+	for {
+		cmd, err := readCommand(r)
+		if err == errProtocol {
+			writeError(w, "ERR Protocol error")
+			_ = w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		quit := s.exec(sess, w, cmd)
+		if r.Buffered() == 0 || quit {
+			if err := w.Flush(); err != nil || quit {
+				return
+			}
+		}
+	}
+}
+
+// session is the state of a connection
+type session struct {
+	authed bool
+	db     int
+}
+
+// exec executes the command and writes its reply, and reports whether the
+// connection should be closed
+func (s *Server) exec(sess *session, w *bufio.Writer, cmd []string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands++
+	name := strings.ToUpper(cmd[0])
+	args := cmd[1:]
+
+	switch name {
+	case "QUIT":
+		writeSimple(w, "OK")
+		return true
+	case "PING":
+		writeSimple(w, "PONG")
+		return false
+	case "AUTH":
+		if len(args) != 1 && len(args) != 2 {
+			writeArity(w, cmd[0])
+		} else if s.pass == "" {
+			writeError(w, "ERR AUTH called without any password configured")
+		} else if args[len(args)-1] != s.pass {
+			writeError(w, "WRONGPASS invalid username-password pair or user is disabled.")
+		} else {
+			sess.authed = true
+			writeSimple(w, "OK")
+		}
+		return false
+	}
+	if !sess.authed {
+		writeError(w, "NOAUTH Authentication required.")
+		return false
+	}
+
+	now := s.now()
+	switch name {
+	case "SELECT":
+		if len(args) != 1 {
+			writeArity(w, cmd[0])
+			break
+		}
+		db, err := strconv.Atoi(args[0])
+		if err != nil || db < 0 || db > 15 {
+			writeError(w, "ERR DB index is out of range")
+			break
+		}
+		sess.db = db
+		writeSimple(w, "OK")
+	case "GET":
+		if len(args) != 1 {
+			writeArity(w, cmd[0])
+			break
+		}
+		if e, ok := s.get(sess.db, args[0]); ok {
+			writeBulk(w, e.value)
+		} else {
+			_, _ = w.WriteString("$-1\r\n")
+		}
+	case "SET":
+		if len(args) != 2 && len(args) != 4 {
+			writeError(w, "ERR syntax error")
+			break
+		}
+		e := entry{value: args[1]}
+		if len(args) == 4 {
+			n, err := strconv.ParseInt(args[3], 10, 64)
+			if err != nil || n <= 0 {
+				writeError(w, "ERR invalid expire time in 'set' command")
+				break
+			}
+			switch strings.ToUpper(args[2]) {
+			case "EX":
+				e.expires = now.Add(time.Duration(n) * time.Second)
+			case "PX":
+				e.expires = now.Add(time.Duration(n) * time.Millisecond)
+			default:
+				writeError(w, "ERR syntax error")
+				return false
+			}
+		}
+		if s.dbs[sess.db] == nil {
+			s.dbs[sess.db] = map[string]entry{}
+		}
+		s.dbs[sess.db][args[0]] = e
+		writeSimple(w, "OK")
+	case "DEL":
+		if len(args) == 0 {
+			writeArity(w, cmd[0])
+			break
+		}
+		deleted := 0
+		for _, key := range args {
+			if _, ok := s.get(sess.db, key); ok {
+				delete(s.dbs[sess.db], key)
+				deleted++
+			}
+		}
+		writeInt(w, int64(deleted))
+	case "PTTL":
+		if len(args) != 1 {
+			writeArity(w, cmd[0])
+			break
+		}
+		e, ok := s.get(sess.db, args[0])
+		switch {
+		case !ok:
+			writeInt(w, -2)
+		case e.expires.IsZero():
+			writeInt(w, -1)
+		default:
+			writeInt(w, int64(e.expires.Sub(now)/time.Millisecond))
+		}
+	case "FLUSHDB":
+		delete(s.dbs, sess.db)
+		writeSimple(w, "OK")
+	default:
+		writeError(w, "ERR unknown command '"+cmd[0]+"'")
+	}
+	return false
+}
+
+// get returns the entry of the key, the expired one is deleted, the lock
+// must be held
+func (s *Server) get(db int, key string) (entry, bool) {
+	e, ok := s.dbs[db][key]
+	if ok && e.expired(s.now()) {
+		delete(s.dbs[db], key)
+		return entry{}, false
+	}
+	return e, ok
+}
+
+// now returns the time of the Server's clock, the lock must be held
+func (s *Server) now() time.Time {
+	return time.Now().Add(s.offset)
+}
+
+func (e entry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// readCommand reads a command, an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	n, err := readHeader(r, '*')
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		return nil, errProtocol
+	}
+	cmd := make([]string, n)
+	for i := range cmd {
+		size, err := readHeader(r, '$')
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, errProtocol
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, errProtocol
+		}
+		cmd[i] = string(buf[:size])
+	}
+	return cmd, nil
+}
+
+// readHeader reads the "<kind><n>\r\n" line, and returns n
+func readHeader(r *bufio.Reader, kind byte) (int, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	if len(line) < 4 || line[0] != kind || line[len(line)-2] != '\r' {
+		return 0, errProtocol
+	}
+	n, err := strconv.Atoi(line[1 : len(line)-2])
+	if err != nil {
+		return 0, errProtocol
+	}
+	return n, nil
+}
+
+func writeSimple(w *bufio.Writer, s string) {
+	_, _ = w.WriteString("+" + s + "\r\n")
+}
+
+func writeError(w *bufio.Writer, s string) {
+	_, _ = w.WriteString("-" + s + "\r\n")
+}
+
+func writeArity(w *bufio.Writer, name string) {
+	writeError(w, "ERR wrong number of arguments for '"+strings.ToLower(name)+"' command")
+}
+
+func writeInt(w *bufio.Writer, n int64) {
+	_, _ = w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func writeBulk(w *bufio.Writer, s string) {
+	_, _ = w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redistest_test
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP/rediscache/redistest"
+)
+
+func TestServer(t *testing.T) {
+	srv := redistest.NewServer()
+	defer srv.Close()
+	srv.RequirePass("secret")
+
+	c, err := net.Dial("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	r := bufio.NewReader(c)
+
+	// pipelined commands
+	tests := []struct {
+		cmd, want string
+	}{
+		{"*1\r\n$4\r\nPING\r\n", "+PONG\r\n"},
+		{"*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", "-NOAUTH Authentication required.\r\n"},
+		{"*2\r\n$4\r\nAUTH\r\n$5\r\nwrong\r\n", "-WRONGPASS invalid username-password pair or user is disabled.\r\n"},
+		{"*2\r\n$4\r\nAUTH\r\n$6\r\nsecret\r\n", "+OK\r\n"},
+		{"*5\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n$2\r\nPX\r\n$4\r\n1000\r\n", "+OK\r\n"},
+		{"*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", "$1\r\nv\r\n"},
+		{"*2\r\n$4\r\nPTTL\r\n$1\r\nk\r\n", ":1000\r\n"},
+		{"*1\r\n$4\r\nKEYS\r\n", "-ERR unknown command 'KEYS'\r\n"},
+	}
+	for _, tt := range tests {
+		if _, err := c.Write([]byte(tt.cmd)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range tests {
+		got := ""
+		for len(got) == 0 || (got[0] == '$' && strings.Count(got, "\n") < 2) {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("reply of %q error = %v", tt.cmd, err)
+			}
+			got += line
+		}
+		if got != tt.want && !(tt.want == ":1000\r\n" && (got == ":999\r\n" || got == ":998\r\n")) {
+			t.Fatalf("reply of %q got = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+
+	if v, ok := srv.Get(0, "k"); !ok || v != "v" {
+		t.Fatalf("Get() got = %v, %v, want %v", v, ok, "v")
+	}
+	srv.FastForward(time.Second)
+	if _, ok := srv.Get(0, "k"); ok {
+		t.Fatalf("Get() of expired key found, want not found")
+	}
+	if srv.Commands() != len(tests) || srv.Connections() != 1 {
+		t.Fatalf("Commands(), Connections() got = %v, %v, want %v, %v", srv.Commands(), srv.Connections(), len(tests), 1)
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rediscache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrClosed is returned when the Cache is used after it is closed
+	ErrClosed = errors.New("rediscache: cache is closed")
+	// errProtocol is returned for the malformed replies of the server
+	errProtocol = errors.New("rediscache: protocol error")
+)
+
+// Error is the error reply of the redis server
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// conn is a connection to the redis server, speaking RESP
+type conn struct {
+	nc net.Conn
+	r  *bufio.Reader
+	w  *bufio.Writer
+}
+
+func newConn(nc net.Conn) *conn {
+	return &conn{nc: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+}
+
+// pipeline writes all the commands at once and reads their replies, before
+// the deadline. The error replies are returned as the Error replies, the
+// error is only returned if the connection is no longer usable.
+func (c *conn) pipeline(deadline time.Time, cmds [][]string) ([]interface{}, error) {
+	if err := c.nc.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	for _, cmd := range cmds {
+		c.write(cmd)
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	replies := make([]interface{}, len(cmds))
+	for i := range replies {
+		reply, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+// write buffers the command as an array of bulk strings, the write errors are
+// returned by the Flush
+func (c *conn) write(cmd []string) {
+	c.header('*', len(cmd))
+	for _, arg := range cmd {
+		c.header('$', len(arg))
+		_, _ = c.w.WriteString(arg)
+		_, _ = c.w.WriteString("\r\n")
+	}
+}
+
+func (c *conn) header(kind byte, n int) {
+	_ = c.w.WriteByte(kind)
+	_, _ = c.w.WriteString(strconv.Itoa(n))
+	_, _ = c.w.WriteString("\r\n")
+}
+
+// read reads a reply: a string for the simple strings, an Error for the
+// errors, an int64 for the integers, a []byte for the bulk strings, an
+// []interface{} for the arrays and nil for the null replies
+func (c *conn) read() (interface{}, error) {
+	line, err := c.line()
+	if err != nil {
+		return nil, err
+	}
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		n, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil {
+			return nil, errProtocol
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n < -1 {
+			return nil, errProtocol
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		if buf[n] != '\r' || buf[n+1] != '\n' {
+			return nil, errProtocol
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n < -1 {
+			return nil, errProtocol
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		array := make([]interface{}, n)
+		for i := range array {
+			if array[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	}
+	return nil, errProtocol
+}
+
+// line reads a line of the reply, without the trailing CRLF
+func (c *conn) line() ([]byte, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, errProtocol
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errProtocol
+	}
+	return line[:len(line)-2], nil
+}
+
+// pool is the pool of the connections to the redis server, at most size of
+// them are in use at a time, and the idle ones are reused
+type pool struct {
+	dial func(ctx context.Context) (*conn, error)
+	sem  chan struct{}
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+func newPool(size int, dial func(ctx context.Context) (*conn, error)) *pool {
+	return &pool{dial: dial, sem: make(chan struct{}, size)}
+}
+
+// get returns an idle connection, or dials a new one, and reports whether the
+// connection is reused. It waits for a connection to be put back, if all of
+// them are in use.
+func (p *pool) get(ctx context.Context) (*conn, bool, error) {
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		<-p.sem
+		return nil, false, ErrClosed
+	}
+	if n := len(p.idle); n > 0 {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return c, true, nil
+	}
+	p.mu.Unlock()
+	c, err := p.dial(ctx)
+	if err != nil {
+		<-p.sem
+		return nil, false, err
+	}
+	return c, false, nil
+}
+
+// put puts the connection back to the pool, the broken ones are closed
+func (p *pool) put(c *conn, broken bool) {
+	p.mu.Lock()
+	if broken || p.closed {
+		_ = c.nc.Close()
+	} else {
+		p.idle = append(p.idle, c)
+	}
+	p.mu.Unlock()
+	<-p.sem
+}
+
+// close closes the idle connections, the ones in use are closed when they
+// are put back
+func (p *pool) close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle, p.closed = nil, true
+	p.mu.Unlock()
+	var err error
+	for _, c := range idle {
+		if closeErr := c.nc.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
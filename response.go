@@ -15,27 +15,29 @@
 package freeGeoIP
 
 import (
+	"bytes"
 	"encoding/json"
+	"encoding/xml"
 	"time"
 )
 
 // Info is the object specifying geo-location information obtained from
 // the application https://freegeoip.app/
 type Info struct {
-	IP IP `json:"ip"`
+	IP IP `json:"ip" xml:"IP"`
 
-	CountryCode string  `json:"country_code"`
-	CountryName string  `json:"country_name"`
-	RegionCode  string  `json:"region_code"`
-	RegionName  string  `json:"region_name"`
-	City        string  `json:"city"`
-	ZipCode     string  `json:"zip_code"`
-	MetroCode   float64 `json:"metro_code"`
+	CountryCode string  `json:"country_code" xml:"CountryCode"`
+	CountryName string  `json:"country_name" xml:"CountryName"`
+	RegionCode  string  `json:"region_code" xml:"RegionCode"`
+	RegionName  string  `json:"region_name" xml:"RegionName"`
+	City        string  `json:"city" xml:"City"`
+	ZipCode     string  `json:"zip_code" xml:"ZipCode"`
+	MetroCode   float64 `json:"metro_code" xml:"MetroCode"`
 
-	TimeZone *Location `json:"time_zone"`
+	TimeZone *Location `json:"time_zone" xml:"TimeZone"`
 
-	Latitude  float64 `json:"latitude"`
-	Longitude float64 `json:"longitude"`
+	Latitude  float64 `json:"latitude" xml:"Latitude"`
+	Longitude float64 `json:"longitude" xml:"Longitude"`
 }
 
 func Decoder(data []byte) (*Info, error) {
@@ -52,6 +54,21 @@ func Decoder(data []byte) (*Info, error) {
 	return info, nil
 }
 
+// XMLDecoder decodes the xml response of the API into the Info
+func XMLDecoder(data []byte) (*Info, error) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, ErrNoResponse
+	}
+	info := &Info{}
+	if err := xml.Unmarshal(data, info); err != nil {
+		return nil, wrapError("decode", err)
+	}
+	if len(info.IP) == 0 {
+		return nil, ErrNoResponse
+	}
+	return info, nil
+}
+
 type Response struct {
 	// Info will contain the geo-location information about the IP provided in
 	// request, and will be nil, if Error is not nil
@@ -67,10 +84,23 @@ type Response struct {
 }
 
 type MetaInfo struct {
-	// duration in which limit will reset
+	// duration in which limit will reset, relative to the time when MetaInfo
+	// was obtained
 	ResetIn time.Duration
+	// time at which limit will reset
+	ResetAt time.Time
 	// total rate limit per hour
 	Limit int64
 	// remaining limit for the resetsIn duration
 	Remaining int64
+	// name of the backend which answered the lookup, if reported by the
+	// Provider, like FailoverProvider
+	Provider string
+	// redacted API key which served the lookup, if any
+	APIKey string
+
+	// uncached marks the information served as a fallback, like the stale or
+	// imprecise Local one of the HybridProvider, which the Client does not
+	// cache, so that it is looked up again
+	uncached bool
 }
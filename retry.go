@@ -0,0 +1,186 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultRetryBaseDelay is the delay before the first retry, used when
+	// RetryPolicy.BaseDelay is not provided
+	DefaultRetryBaseDelay = 100 * time.Millisecond
+	// DefaultRetryMaxDelay is the maximum delay between the retries, used when
+	// RetryPolicy.MaxDelay is not provided
+	DefaultRetryMaxDelay = 2 * time.Second
+)
+
+// RetryPolicy is the policy for retrying the API calls failed due to the
+// transient upstream failures, with an exponential backoff and jitter.
+// The `ErrLimitReached` and the `ErrNoResponse` failures are never retried and
+// the retries stop as soon as the context is done. The `ErrInvalidKey` failures
+// are not retried either.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first one
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, which is doubled for
+	// every next retry
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between the retries. If the server asks, using
+	// the Retry-After header, to wait longer than MaxDelay, then the call is
+	// not retried
+	MaxDelay time.Duration
+	// Jitter is the fraction, in [0, 1], of the delay which is randomised
+	Jitter float64
+	// Retryable classifies the failures as retryable, if not provided
+	// IsRetryable will be used. resp is nil, if err is not nil
+	Retryable func(resp *http.Response, err error) bool
+}
+
+// DefaultRetryPolicy is the library default retry policy of 3 attempts
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   DefaultRetryBaseDelay,
+		MaxDelay:    DefaultRetryMaxDelay,
+		Jitter:      0.2,
+	}
+}
+
+// IsRetryable is the default classification of the retryable failures, which
+// are the timeouts, the connection resets, the 5xx and the 429 responses
+func IsRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			return true
+		}
+		return errors.Is(err, syscall.ECONNRESET) ||
+			errors.Is(err, io.EOF) ||
+			errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	if resp == nil {
+		return false
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// backoff reports whether the failed attempt should be retried and the delay
+// to wait before the retry
+func (p *RetryPolicy) backoff(attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	if p == nil || attempt >= p.MaxAttempts {
+		return 0, false
+	}
+	if err == nil && resp != nil {
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+			return 0, false
+		}
+	}
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	if !retryable(resp, err) {
+		return 0, false
+	}
+
+	base, max := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = DefaultRetryBaseDelay
+	}
+	if max <= 0 {
+		max = DefaultRetryMaxDelay
+	}
+	if after, ok := retryAfter(resp); ok {
+		if after > max {
+			return 0, false
+		}
+		return after, true
+	}
+	delay := base << uint(attempt-1)
+	if delay > max || delay <= 0 {
+		delay = max
+	}
+	if p.Jitter > 0 {
+		delay -= time.Duration(p.Jitter * rand.Float64() * float64(delay))
+	}
+	return delay, true
+}
+
+// retryAfter parses the Retry-After header of the response, in either
+// seconds or http date format
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	val := resp.Header.Get("Retry-After")
+	if val == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(val); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(val); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// roundTrip sends the request and retries it as per the RetryPolicy, every
+// attempt waits for the client side rate limit
+func (p *HTTPProvider) roundTrip(ctx context.Context, req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		if err := p.limiter().wait(ctx); err != nil {
+			p.log("rate limiter error:", err)
+			return nil, err
+		}
+		resp, err := p.httpCli().Do(req.WithContext(ctx))
+		delay, retry := p.Retry.backoff(attempt, resp, err)
+		if !retry || ctx.Err() != nil {
+			return resp, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return resp, err
+		}
+		if resp != nil {
+			p.log("attempt", attempt, "failed with status:", resp.Status, "retrying in", delay)
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		} else {
+			p.log("attempt", attempt, "failed with error:", err, "retrying in", delay)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
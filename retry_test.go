@@ -0,0 +1,160 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  func(w http.ResponseWriter)
+		failTimes int32
+		wantCalls int32
+		err       error
+	}{
+		{
+			name:      "Service Unavailable",
+			failures:  func(w http.ResponseWriter) { w.WriteHeader(http.StatusServiceUnavailable) },
+			failTimes: 2,
+			wantCalls: 3,
+		},
+		{
+			name: "Too Many Requests",
+			failures: func(w http.ResponseWriter) {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+			failTimes: 1,
+			wantCalls: 2,
+		},
+		{
+			name: "Connection Reset",
+			failures: func(w http.ResponseWriter) {
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				conn.Close()
+			},
+			failTimes: 1,
+			wantCalls: 2,
+		},
+		{
+			name:      "Attempts Exhausted",
+			failures:  func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadGateway) },
+			failTimes: 5,
+			wantCalls: 3,
+			err:       freeGeoIP.ErrInternal,
+		},
+		{
+			name: "Retry After Too Long",
+			failures: func(w http.ResponseWriter) {
+				w.Header().Set("Retry-After", "3600")
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+			failTimes: 1,
+			wantCalls: 1,
+			err:       freeGeoIP.ErrInternal,
+		},
+		{
+			name:      "Limit Reached",
+			failures:  func(w http.ResponseWriter) { w.WriteHeader(http.StatusForbidden) },
+			failTimes: 1,
+			wantCalls: 1,
+			err:       freeGeoIP.ErrLimitReached,
+		},
+		{
+			name:      "No Response",
+			failures:  func(w http.ResponseWriter) { w.WriteHeader(http.StatusNotFound) },
+			failTimes: 1,
+			wantCalls: 1,
+			err:       freeGeoIP.ErrNoResponse,
+		},
+		{
+			name:      "Bad Request",
+			failures:  func(w http.ResponseWriter) { w.WriteHeader(http.StatusBadRequest) },
+			failTimes: 1,
+			wantCalls: 1,
+			err:       freeGeoIP.ErrInternal,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&calls, 1) <= tt.failTimes {
+					tt.failures(w)
+					return
+				}
+				_, _ = w.Write([]byte(`{"ip":"` + dnsIP + `","time_zone":""}`))
+			}))
+			defer srv.Close()
+
+			cli := &freeGeoIP.Client{
+				BaseURL: srv.URL,
+				Retry: &freeGeoIP.RetryPolicy{
+					MaxAttempts: 3,
+					BaseDelay:   time.Millisecond,
+					MaxDelay:    10 * time.Millisecond,
+					Jitter:      0.5,
+				},
+			}
+			res := cli.GetGeoInfoFromString(context.Background(), dnsIP)
+			if res.Error != tt.err {
+				t.Fatalf("GetGeoInfoFromString() error = %v, want %v", res.Error, tt.err)
+			}
+			if calls != tt.wantCalls {
+				t.Fatalf("API calls got = %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_ContextDone(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	cli := &freeGeoIP.Client{
+		BaseURL: srv.URL,
+		Retry: &freeGeoIP.RetryPolicy{
+			MaxAttempts: 10,
+			BaseDelay:   time.Second,
+			MaxDelay:    time.Second,
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	st := time.Now()
+	res := cli.GetGeoInfoFromString(ctx, dnsIP)
+	if res.Error == nil {
+		t.Fatalf("GetGeoInfoFromString() error = nil, want error")
+	}
+	if calls != 1 {
+		t.Fatalf("API calls got = %v, want %v", calls, 1)
+	}
+	if since := time.Since(st); since > 500*time.Millisecond {
+		t.Fatalf("GetGeoInfoFromString() took %v, must stop before the context deadline", since)
+	}
+}
@@ -0,0 +1,347 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLatencyPenalty is the cost of a second of the latency of a Route,
+	// the mean of its p50 and p95, when the RoutingProvider.LatencyPenalty is
+	// not provided
+	DefaultLatencyPenalty = 0.001
+	// DefaultErrorPenalty is the cost of the error rate of a Route, when the
+	// RoutingProvider.ErrorPenalty is not provided
+	DefaultErrorPenalty = 0.01
+	// DefaultQuotaPenalty is the cost of the used fraction of the quota of a
+	// Route, when the RoutingProvider.QuotaPenalty is not provided
+	DefaultQuotaPenalty = 0.0001
+	// DefaultRoutingWindow is the number of the recent lookups of a Route its
+	// statistics are computed from, when the RoutingProvider.Window is not
+	// provided
+	DefaultRoutingWindow = 100
+	// DefaultRoutingStatsTTL is the age after which the lookups are dropped
+	// from the statistics of a Route, so that a degraded Route is tried again,
+	// when the RoutingProvider.StatsTTL is not provided
+	DefaultRoutingStatsTTL = time.Minute
+)
+
+// Precision is the precision of the information needed for a lookup, see
+// WithPrecision
+type Precision int
+
+const (
+	// PrecisionAny is the default, any Route can answer the lookup
+	PrecisionAny Precision = iota
+	// PrecisionCountry is the hint that only the country is needed, so the
+	// Routes with only the country level information, usually the cheap
+	// local ones, are preferred
+	PrecisionCountry
+	// PrecisionCity is the hint that the city is needed, so that the Routes
+	// with only the country level information are not used, and the Client
+	// does not answer from the cached information without a city
+	PrecisionCity
+)
+
+// errImprecise is the cache miss of the information without a city, for the
+// PrecisionCity lookups
+const errImprecise = _Error("cache: info is not precise enough")
+
+type precisionKey struct{}
+
+// WithPrecision returns the context with the Precision hint for the lookups
+// made with it, used by the RoutingProvider
+func WithPrecision(ctx context.Context, p Precision) context.Context {
+	return context.WithValue(ctx, precisionKey{}, p)
+}
+
+// PrecisionFromContext returns the Precision hint of the context
+func PrecisionFromContext(ctx context.Context) Precision {
+	p, _ := ctx.Value(precisionKey{}).(Precision)
+	return p
+}
+
+// precise reports whether the info is precise enough for the Precision hint
+// of the context
+func precise(ctx context.Context, info *Info) bool {
+	return PrecisionFromContext(ctx) != PrecisionCity || info.City != ""
+}
+
+// Route is a Provider of the RoutingProvider, with its monetary Cost per call,
+// in any unit, and whether it has only the country level information
+type Route struct {
+	Name        string
+	Provider    Provider
+	Cost        float64
+	CountryOnly bool
+}
+
+// RouteStats are the observed statistics of a Route
+type RouteStats struct {
+	Name string
+	// Samples is the number of the recent lookups, the statistics are
+	// computed from
+	Samples   int
+	P50       time.Duration
+	P95       time.Duration
+	ErrorRate float64
+	// Limit, Remaining and ResetAt are the last reported quota of the Route,
+	// the Limit is zero if it was never reported
+	Limit     int64
+	Remaining int64
+	ResetAt   time.Time
+	// SkipUntil is the time till which the Route is skipped, as it reached
+	// its limit
+	SkipUntil time.Time
+	// Score is the expected cost of a lookup with the Route, lower is better
+	Score float64
+}
+
+// RoutingProvider is the Provider that routes every lookup to the Route with
+// the lowest expected cost: its Cost, plus its latency (the mean of its p50
+// and p95), error rate and used quota weighted by the LatencyPenalty, the
+// ErrorPenalty and the QuotaPenalty respectively, in the unit of the Cost. So
+// it stays with the cheap Routes, until they degrade. The Routes which reached
+// their limit are skipped until their quota resets, and the failed lookups are
+// retried with the next best Route, see FailoverProvider.ShouldFailover. The
+// lookups answered with ErrNoResponse are always retried with the next Route,
+// so that an ip missing from the cheap ones is looked up with the others.
+// The Precision hint of the context, see WithPrecision, orders the CountryOnly
+// Routes first for PrecisionCountry, and last, only as the fallback, for
+// PrecisionCity.
+// If the penalties, Window or StatsTTL are not provided, their defaults, like
+// the DefaultLatencyPenalty, are used
+// If Score is provided, it is used to score the Routes instead
+type RoutingProvider struct {
+	Routes         []Route
+	LatencyPenalty float64
+	ErrorPenalty   float64
+	QuotaPenalty   float64
+	Window         int
+	StatsTTL       time.Duration
+	Score          func(route Route, stats RouteStats) float64
+	ShouldFailover func(err error) bool
+
+	mu     sync.Mutex
+	states map[int]*routeState
+	skips  skipList
+}
+
+// routeState are the recent lookups and the quota of a Route
+type routeState struct {
+	samples []routeSample
+	next    int
+	meta    MetaInfo
+}
+
+// routeSample is the outcome of a lookup
+type routeSample struct {
+	at      time.Time
+	latency time.Duration
+	failed  bool
+}
+
+// NewRoutingProvider returns the RoutingProvider of the routes
+func NewRoutingProvider(routes ...Route) *RoutingProvider {
+	return &RoutingProvider{Routes: routes}
+}
+
+// Lookup looks up the ip with the best Route, see RoutingProvider
+func (r *RoutingProvider) Lookup(ctx context.Context, ip IP) (*Info, *MetaInfo, error) {
+	order, earliest := r.order(PrecisionFromContext(ctx))
+	if len(order) == 0 {
+		return nil, &MetaInfo{ResetIn: time.Until(earliest), ResetAt: earliest}, ErrLimitReached
+	}
+	var (
+		lastErr  error
+		lastMeta *MetaInfo
+	)
+	for _, i := range order {
+		route := r.Routes[i]
+		start := time.Now()
+		info, meta, err := route.Provider.Lookup(ctx, ip)
+		r.observe(i, time.Since(start), meta, err)
+		meta = namedMeta(meta, route.name(i))
+		if err == nil {
+			return info, meta, nil
+		}
+		lastErr, lastMeta = err, meta
+		// a Route without a record, like an offline database, is not the
+		// answer while a next Route may have it
+		if ctx.Err() != nil || (err != ErrNoResponse && !shouldFailover(r.ShouldFailover, err)) {
+			break
+		}
+	}
+	return nil, lastMeta, lastErr
+}
+
+// Stats returns the snapshot of the statistics of every Route, in order
+func (r *RoutingProvider) Stats() []RouteStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	stats := make([]RouteStats, len(r.Routes))
+	for i := range r.Routes {
+		stats[i] = r.stats(i, now)
+	}
+	return stats
+}
+
+// name returns the Name of the Route, or its position if not provided
+func (rt Route) name(i int) string {
+	if rt.Name != "" {
+		return rt.Name
+	}
+	return "route-" + strconv.Itoa(i)
+}
+
+// order returns the positions of the eligible Routes for the precision, best
+// first, or the earliest reset if every Route is skipped. The Routes which
+// suit the precision, the CountryOnly ones for PrecisionCountry and the
+// others for PrecisionCity, are ordered before the rest.
+func (r *RoutingProvider) order(p Precision) ([]int, time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	var (
+		order    []int
+		fallback []int
+		earliest time.Time
+	)
+	scores := make(map[int]float64, len(r.Routes))
+	for i, route := range r.Routes {
+		stats := r.stats(i, now)
+		if stats.SkipUntil.After(now) {
+			if earliest.IsZero() || stats.SkipUntil.Before(earliest) {
+				earliest = stats.SkipUntil
+			}
+			continue
+		}
+		scores[i] = stats.Score
+		if (p == PrecisionCity && route.CountryOnly) || (p == PrecisionCountry && !route.CountryOnly) {
+			fallback = append(fallback, i)
+			continue
+		}
+		order = append(order, i)
+	}
+	byScore := func(s []int) {
+		sort.SliceStable(s, func(a, b int) bool { return scores[s[a]] < scores[s[b]] })
+	}
+	byScore(order)
+	byScore(fallback)
+	return append(order, fallback...), earliest
+}
+
+// stats returns the statistics of the ith Route, the lock must be held
+func (r *RoutingProvider) stats(i int, now time.Time) RouteStats {
+	stats := RouteStats{Name: r.Routes[i].name(i)}
+	if until, ok := r.skips.skipped(i, now); ok {
+		stats.SkipUntil = until
+	}
+	state := r.states[i]
+	if state == nil {
+		stats.Score = r.score(r.Routes[i], stats)
+		return stats
+	}
+
+	ttl := r.StatsTTL
+	if ttl <= 0 {
+		ttl = DefaultRoutingStatsTTL
+	}
+	var (
+		latencies []time.Duration
+		failed    int
+	)
+	for _, s := range state.samples {
+		if now.Sub(s.at) > ttl {
+			continue
+		}
+		latencies = append(latencies, s.latency)
+		if s.failed {
+			failed++
+		}
+	}
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(a, b int) bool { return latencies[a] < latencies[b] })
+		stats.Samples = len(latencies)
+		stats.P50 = latencies[(len(latencies)-1)*50/100]
+		stats.P95 = latencies[(len(latencies)-1)*95/100]
+		stats.ErrorRate = float64(failed) / float64(len(latencies))
+	}
+	if state.meta.ResetAt.After(now) {
+		stats.Limit, stats.Remaining, stats.ResetAt = state.meta.Limit, state.meta.Remaining, state.meta.ResetAt
+	}
+	stats.Score = r.score(r.Routes[i], stats)
+	return stats
+}
+
+// score returns the expected cost of a lookup with the Route
+func (r *RoutingProvider) score(route Route, stats RouteStats) float64 {
+	if r.Score != nil {
+		return r.Score(route, stats)
+	}
+	penalty := func(v, def float64) float64 {
+		if v <= 0 {
+			return def
+		}
+		return v
+	}
+	latency := (stats.P50 + stats.P95).Seconds() / 2
+	score := route.Cost +
+		latency*penalty(r.LatencyPenalty, DefaultLatencyPenalty) +
+		stats.ErrorRate*penalty(r.ErrorPenalty, DefaultErrorPenalty)
+	if stats.Limit > 0 {
+		used := 1 - float64(stats.Remaining)/float64(stats.Limit)
+		score += used * penalty(r.QuotaPenalty, DefaultQuotaPenalty)
+	}
+	return score
+}
+
+// observe records the outcome of a lookup with the ith Route
+func (r *RoutingProvider) observe(i int, latency time.Duration, meta *MetaInfo, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.states == nil {
+		r.states = map[int]*routeState{}
+	}
+	state := r.states[i]
+	if state == nil {
+		state = &routeState{}
+		r.states[i] = state
+	}
+
+	window := r.Window
+	if window <= 0 {
+		window = DefaultRoutingWindow
+	}
+	// ErrNoResponse is a valid answer, not a failure of the Route
+	sample := routeSample{at: time.Now(), latency: latency, failed: err != nil && err != ErrNoResponse}
+	if len(state.samples) < window {
+		state.samples = append(state.samples, sample)
+	} else {
+		state.samples[state.next%len(state.samples)] = sample
+		state.next++
+	}
+
+	if meta != nil && !meta.ResetAt.IsZero() {
+		state.meta = *meta
+	}
+	r.skips.observe(i, meta, err)
+}
@@ -0,0 +1,293 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP"
+)
+
+// routeProvider is a Provider with a configurable latency and error
+type routeProvider struct {
+	mu    sync.Mutex
+	info  *freeGeoIP.Info
+	meta  *freeGeoIP.MetaInfo
+	err   error
+	delay time.Duration
+	calls int
+}
+
+func (p *routeProvider) Lookup(_ context.Context, ip freeGeoIP.IP) (*freeGeoIP.Info, *freeGeoIP.MetaInfo, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	time.Sleep(p.delay)
+	if p.err != nil {
+		return nil, p.meta, p.err
+	}
+	tmp := *p.info
+	tmp.IP = ip
+	return &tmp, p.meta, nil
+}
+
+func (p *routeProvider) set(err error, meta *freeGeoIP.MetaInfo) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err, p.meta = err, meta
+}
+
+func (p *routeProvider) Calls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+func TestRoutingProvider(t *testing.T) {
+	free := &routeProvider{info: response()}
+	premium := &routeProvider{info: response()}
+	router := freeGeoIP.NewRoutingProvider(
+		freeGeoIP.Route{Name: "premium", Provider: premium, Cost: 0.004},
+		freeGeoIP.Route{Name: "free", Provider: free},
+	)
+	router.StatsTTL = 100 * time.Millisecond
+	ctx := context.Background()
+
+	// cheap under normal load
+	for i := 0; i < 5; i++ {
+		info, meta, err := router.Lookup(ctx, freeGeoIP.ParseIP(dnsIP))
+		if err != nil || info.City != "Belgaum" || meta.Provider != "free" {
+			t.Fatalf("Lookup() got = %+v, %+v, %v", info, meta, err)
+		}
+	}
+	if free.Calls() != 5 || premium.Calls() != 0 {
+		t.Fatalf("calls got = %v, %v, want %v, %v", free.Calls(), premium.Calls(), 5, 0)
+	}
+
+	// free degrades, the failed lookups are retried with the premium
+	free.set(errors.New("http: connection reset"), nil)
+	for i := 0; i < 10; i++ {
+		_, meta, err := router.Lookup(ctx, freeGeoIP.ParseIP(dnsIP))
+		if err != nil || meta.Provider != "premium" {
+			t.Fatalf("Lookup() got = %+v, %v", meta, err)
+		}
+	}
+	// once the error rate outweighs the cost, the free is not tried anymore
+	if calls := free.Calls(); calls >= 15 {
+		t.Fatalf("free calls got = %v, want less than %v", calls, 15)
+	}
+	stats := router.Stats()
+	if stats[0].Name != "premium" || stats[1].ErrorRate < 0.4 || stats[1].Score <= stats[0].Score {
+		t.Fatalf("Stats() got = %+v", stats)
+	}
+
+	// the degraded statistics expire, and the free is tried again
+	free.set(nil, nil)
+	time.Sleep(router.StatsTTL + 10*time.Millisecond)
+	if _, meta, err := router.Lookup(ctx, freeGeoIP.ParseIP(dnsIP)); err != nil || meta.Provider != "free" {
+		t.Fatalf("Lookup() got = %+v, %v", meta, err)
+	}
+
+	// not found by the free one, the premium is asked
+	free.set(freeGeoIP.ErrNoResponse, nil)
+	before := premium.Calls()
+	if _, meta, err := router.Lookup(ctx, freeGeoIP.ParseIP(dnsIP)); err != nil || meta.Provider != "premium" {
+		t.Fatalf("Lookup() got = %+v, %v", meta, err)
+	}
+	if premium.Calls() != before+1 {
+		t.Fatalf("premium calls got = %v, want %v", premium.Calls(), before+1)
+	}
+
+	// not found by any, it is the answer
+	premium.set(freeGeoIP.ErrNoResponse, nil)
+	if _, _, err := router.Lookup(ctx, freeGeoIP.ParseIP(dnsIP)); err != freeGeoIP.ErrNoResponse {
+		t.Fatalf("Lookup() error = %v, want %v", err, freeGeoIP.ErrNoResponse)
+	}
+	// not found is not a failure of the Route
+	if stats := router.Stats(); stats[1].ErrorRate != 0 {
+		t.Fatalf("Stats() got = %+v", stats[1])
+	}
+}
+
+func TestRoutingProviderLatency(t *testing.T) {
+	slow := &routeProvider{info: response(), delay: 30 * time.Millisecond}
+	fast := &routeProvider{info: response()}
+	router := freeGeoIP.NewRoutingProvider(
+		freeGeoIP.Route{Name: "slow", Provider: slow},
+		freeGeoIP.Route{Name: "fast", Provider: fast, Cost: 0.00001},
+	)
+	router.LatencyPenalty = 1 // a second costs 1
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		if _, _, err := router.Lookup(ctx, freeGeoIP.ParseIP(dnsIP)); err != nil {
+			t.Fatalf("Lookup() error = %v, want no error", err)
+		}
+	}
+	// the slow one is tried first, until its latency is known
+	if slow.Calls() != 1 || fast.Calls() != 4 {
+		t.Fatalf("calls got = %v, %v, want %v, %v", slow.Calls(), fast.Calls(), 1, 4)
+	}
+	stats := router.Stats()
+	if stats[0].Samples != 1 || stats[0].P50 < 30*time.Millisecond || stats[0].P95 < stats[0].P50 {
+		t.Fatalf("Stats() got = %+v", stats[0])
+	}
+}
+
+func TestRoutingProviderPrecision(t *testing.T) {
+	country := &routeProvider{info: &freeGeoIP.Info{CountryCode: "IN"}}
+	city := &routeProvider{info: response()}
+	router := freeGeoIP.NewRoutingProvider(
+		freeGeoIP.Route{Name: "city", Provider: city, Cost: 0.001},
+		freeGeoIP.Route{Name: "country", Provider: country, Cost: 0.002, CountryOnly: true},
+	)
+	tests := []struct {
+		precision freeGeoIP.Precision
+		want      string
+	}{
+		{freeGeoIP.PrecisionAny, "city"},
+		{freeGeoIP.PrecisionCountry, "country"},
+		{freeGeoIP.PrecisionCity, "city"},
+	}
+	for _, tt := range tests {
+		ctx := freeGeoIP.WithPrecision(context.Background(), tt.precision)
+		if freeGeoIP.PrecisionFromContext(ctx) != tt.precision {
+			t.Fatalf("PrecisionFromContext() got = %v, want %v", freeGeoIP.PrecisionFromContext(ctx), tt.precision)
+		}
+		if _, meta, err := router.Lookup(ctx, freeGeoIP.ParseIP(dnsIP)); err != nil || meta.Provider != tt.want {
+			t.Fatalf("Lookup(%v) got = %+v, %v, want %v", tt.precision, meta, err, tt.want)
+		}
+	}
+
+	// the country only route is the last resort for the city
+	city.set(errors.New("http: timeout"), nil)
+	ctx := freeGeoIP.WithPrecision(context.Background(), freeGeoIP.PrecisionCity)
+	if _, meta, err := router.Lookup(ctx, freeGeoIP.ParseIP(dnsIP)); err != nil || meta.Provider != "country" {
+		t.Fatalf("Lookup() got = %+v, %v", meta, err)
+	}
+}
+
+func TestRoutingProviderNoRecord(t *testing.T) {
+	local := &tableProvider{table: map[string]*freeGeoIP.Info{responseIP: {CountryCode: "IN"}}}
+	paid := &routeProvider{info: response()}
+	router := freeGeoIP.NewRoutingProvider(
+		freeGeoIP.Route{Name: "local", Provider: local, CountryOnly: true},
+		freeGeoIP.Route{Name: "paid", Provider: paid, Cost: 0.001},
+	)
+	ctx := freeGeoIP.WithPrecision(context.Background(), freeGeoIP.PrecisionCountry)
+	if info, meta, err := router.Lookup(ctx, freeGeoIP.ParseIP(responseIP)); err != nil || meta.Provider != "local" || info.CountryCode != "IN" {
+		t.Fatalf("Lookup() got = %+v, %+v, %v", info, meta, err)
+	}
+
+	// the ip missing from the local database is paid for
+	info, meta, err := router.Lookup(ctx, freeGeoIP.ParseIP(dnsIP))
+	if err != nil || meta.Provider != "paid" || info.City != "Belgaum" {
+		t.Fatalf("Lookup() got = %+v, %+v, %v", info, meta, err)
+	}
+	if local.Calls() != 2 || paid.Calls() != 1 {
+		t.Fatalf("calls got = %v, %v, want %v, %v", local.Calls(), paid.Calls(), 2, 1)
+	}
+}
+
+func TestRoutingProviderPrecisionCache(t *testing.T) {
+	country := &routeProvider{info: &freeGeoIP.Info{CountryCode: "IN", TimeZone: response().TimeZone}}
+	city := &routeProvider{info: response()}
+	router := freeGeoIP.NewRoutingProvider(
+		freeGeoIP.Route{Name: "city", Provider: city, Cost: 0.001},
+		freeGeoIP.Route{Name: "country", Provider: country, CountryOnly: true},
+	)
+	cli := &freeGeoIP.Client{Cache: freeGeoIP.DefaultCache(), Provider: router}
+	ip := freeGeoIP.ParseIP(responseIP)
+
+	// the cached country is enough for any precision but the city
+	if res := cli.GetGeoInfo(context.Background(), ip); res.Error != nil || res.Info.City != "" {
+		t.Fatalf("GetGeoInfo() got = %+v", res)
+	}
+	if res := cli.GetGeoInfo(context.Background(), ip); !res.Cached {
+		t.Fatalf("GetGeoInfo() for second call output must be cached")
+	}
+	ctx := freeGeoIP.WithPrecision(context.Background(), freeGeoIP.PrecisionCity)
+	res := cli.GetGeoInfo(ctx, ip)
+	if res.Error != nil || res.Cached || res.Info.City != "Belgaum" || city.Calls() != 1 {
+		t.Fatalf("GetGeoInfo() with city precision got = %+v, city calls = %v", res, city.Calls())
+	}
+	if res := cli.GetGeoInfoBatch(ctx, []freeGeoIP.IP{ip}, nil)[0]; !res.Cached || res.Info.City != "Belgaum" {
+		t.Fatalf("GetGeoInfoBatch() with city precision got = %+v", res)
+	}
+}
+
+func TestRoutingProviderScore(t *testing.T) {
+	spiky := &routeProvider{info: response()}
+	router := freeGeoIP.NewRoutingProvider(freeGeoIP.Route{Name: "spiky", Provider: spiky, Cost: 0.001})
+	for i := 0; i < 20; i++ {
+		spiky.mu.Lock()
+		spiky.delay = 0
+		if i >= 18 {
+			spiky.delay = 40 * time.Millisecond
+		}
+		spiky.mu.Unlock()
+		if _, _, err := router.Lookup(context.Background(), freeGeoIP.ParseIP(dnsIP)); err != nil {
+			t.Fatalf("Lookup() error = %v, want no error", err)
+		}
+	}
+
+	// the p50 and the p95 latencies are weighed together
+	stats := router.Stats()[0]
+	if stats.P50 >= 10*time.Millisecond || stats.P95 < 40*time.Millisecond {
+		t.Fatalf("Stats() got = %+v", stats)
+	}
+	want := 0.001 + (stats.P50+stats.P95).Seconds()/2*freeGeoIP.DefaultLatencyPenalty
+	if diff := stats.Score - want; diff > 1e-9 || diff < -1e-9 {
+		t.Fatalf("Stats() score got = %v, want %v", stats.Score, want)
+	}
+}
+
+func TestRoutingProviderQuota(t *testing.T) {
+	resetAt := time.Now().Add(time.Hour)
+	limited := &routeProvider{info: response(), meta: &freeGeoIP.MetaInfo{Limit: 10, Remaining: 1, ResetAt: resetAt}}
+	paid := &routeProvider{info: response()}
+	router := freeGeoIP.NewRoutingProvider(
+		freeGeoIP.Route{Name: "limited", Provider: limited},
+		freeGeoIP.Route{Name: "paid", Provider: paid, Cost: 0.001},
+	)
+	ctx := context.Background()
+	if _, meta, err := router.Lookup(ctx, freeGeoIP.ParseIP(dnsIP)); err != nil || meta.Provider != "limited" {
+		t.Fatalf("Lookup() got = %+v, %v", meta, err)
+	}
+
+	// exhausted, skipped until the reset
+	limited.set(freeGeoIP.ErrLimitReached, &freeGeoIP.MetaInfo{Limit: 10, ResetAt: resetAt})
+	for i := 0; i < 3; i++ {
+		if _, meta, err := router.Lookup(ctx, freeGeoIP.ParseIP(dnsIP)); err != nil || meta.Provider != "paid" {
+			t.Fatalf("Lookup() got = %+v, %v", meta, err)
+		}
+	}
+	if limited.Calls() != 2 {
+		t.Fatalf("limited calls got = %v, want %v", limited.Calls(), 2)
+	}
+	if stats := router.Stats(); !stats[0].SkipUntil.Equal(resetAt) {
+		t.Fatalf("Stats() got = %+v", stats[0])
+	}
+
+	paid.set(freeGeoIP.ErrLimitReached, &freeGeoIP.MetaInfo{Limit: 10, ResetAt: resetAt.Add(time.Minute)})
+	_, _, _ = router.Lookup(ctx, freeGeoIP.ParseIP(dnsIP))
+	_, meta, err := router.Lookup(ctx, freeGeoIP.ParseIP(dnsIP))
+	if err != freeGeoIP.ErrLimitReached || !meta.ResetAt.Equal(resetAt) {
+		t.Fatalf("Lookup() got = %+v, %v, want %v", meta, err, freeGeoIP.ErrLimitReached)
+	}
+}
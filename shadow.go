@@ -0,0 +1,273 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultShadowTimeout is the timeout of the shadow lookups, when the
+	// ShadowProvider.Timeout is not provided
+	DefaultShadowTimeout = 5 * time.Second
+	// DefaultShadowInFlight is the maximum number of the concurrent shadow
+	// lookups, when the ShadowProvider.MaxInFlight is not provided
+	DefaultShadowInFlight = 64
+
+	// earthRadius is the mean radius of the earth in kilometers
+	earthRadius = 6371.0
+)
+
+// ShadowDiff is the field level difference between the Info of the primary
+// and the shadow Provider for an ip
+type ShadowDiff struct {
+	IP      IP
+	Primary *Info
+	Shadow  *Info
+	// Error is the error of the shadow lookup, the mismatches are not
+	// compared if it is not nil
+	Error error
+
+	CountryMismatch bool
+	RegionMismatch  bool
+	CityMismatch    bool
+	// Distance is the distance between the coordinates in kilometers, and is
+	// negative if either of them has no coordinates
+	Distance float64
+}
+
+// ShadowStats are the aggregate agreement statistics of the ShadowProvider
+type ShadowStats struct {
+	// Sampled is the number of the lookups shadowed, and Dropped is the
+	// number of the sampled lookups dropped as MaxInFlight was reached
+	Sampled int64
+	Dropped int64
+	// Compared is the number of the lookups compared, the shadow lookups
+	// which failed are counted in Errors instead
+	Compared int64
+	Errors   int64
+
+	CountryMatches int64
+	RegionMatches  int64
+	CityMatches    int64
+
+	// Located is the number of the compared lookups with the coordinates on
+	// both sides, with the TotalDistance and the MaxDistance between them
+	Located       int64
+	TotalDistance float64
+	MaxDistance   float64
+}
+
+// CountryAgreement returns the fraction of the compared lookups with the same
+// country
+func (s ShadowStats) CountryAgreement() float64 {
+	return ratio(s.CountryMatches, s.Compared)
+}
+
+// RegionAgreement returns the fraction of the compared lookups with the same
+// region
+func (s ShadowStats) RegionAgreement() float64 {
+	return ratio(s.RegionMatches, s.Compared)
+}
+
+// CityAgreement returns the fraction of the compared lookups with the same
+// city
+func (s ShadowStats) CityAgreement() float64 {
+	return ratio(s.CityMatches, s.Compared)
+}
+
+// MeanDistance returns the mean distance between the coordinates in
+// kilometers
+func (s ShadowStats) MeanDistance() float64 {
+	if s.Located == 0 {
+		return 0
+	}
+	return s.TotalDistance / float64(s.Located)
+}
+
+func ratio(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+// ShadowProvider is the Provider that always answers from the Primary, while
+// looking up a sample of the ips with the Shadow asynchronously, to compare
+// their answers. The lookups which the Primary could not answer are not
+// shadowed.
+// If SampleRate, between 0 and 1, is not provided, every lookup is shadowed
+// If Timeout is not provided, DefaultShadowTimeout is used
+// If MaxInFlight is not provided, DefaultShadowInFlight is used
+// If OnDiff is provided, it is called with every comparison, from the
+// goroutine of the shadow lookup
+type ShadowProvider struct {
+	Primary     Provider
+	Shadow      Provider
+	SampleRate  float64
+	Timeout     time.Duration
+	MaxInFlight int
+	OnDiff      func(diff ShadowDiff)
+
+	mu       sync.Mutex
+	stats    ShadowStats
+	inFlight int
+	wg       sync.WaitGroup
+}
+
+// Lookup looks up the ip with the Primary, see ShadowProvider
+func (s *ShadowProvider) Lookup(ctx context.Context, ip IP) (*Info, *MetaInfo, error) {
+	info, meta, err := s.Primary.Lookup(ctx, ip)
+	if err != nil || info == nil || !s.sample() {
+		return info, meta, err
+	}
+	primary := copyInfo(info)
+	ctx = detachedContext{ctx}
+	go func() {
+		defer s.done()
+		s.compare(ctx, ip, primary)
+	}()
+	return info, meta, err
+}
+
+// Stats returns the snapshot of the aggregate statistics
+func (s *ShadowProvider) Stats() ShadowStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// Wait waits for the shadow lookups in flight to complete
+func (s *ShadowProvider) Wait() {
+	s.wg.Wait()
+}
+
+// sample reports whether the lookup should be shadowed, and reserves its
+// place in flight
+func (s *ShadowProvider) sample() bool {
+	if s.SampleRate > 0 && s.SampleRate < 1 && rand.Float64() >= s.SampleRate {
+		return false
+	}
+	max := s.MaxInFlight
+	if max <= 0 {
+		max = DefaultShadowInFlight
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Sampled++
+	if s.inFlight >= max {
+		s.stats.Dropped++
+		return false
+	}
+	s.inFlight++
+	s.wg.Add(1)
+	return true
+}
+
+// done releases the place in flight
+func (s *ShadowProvider) done() {
+	s.mu.Lock()
+	s.inFlight--
+	s.mu.Unlock()
+	s.wg.Done()
+}
+
+// compare looks up the ip with the Shadow and records its difference from
+// the primary
+func (s *ShadowProvider) compare(ctx context.Context, ip IP, primary *Info) {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = DefaultShadowTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	shadow, _, err := s.Shadow.Lookup(ctx, ip)
+	if err == nil && shadow == nil {
+		err = ErrNoResponse
+	}
+	diff := Diff(primary, shadow)
+	diff.IP, diff.Error = ip, err
+
+	s.mu.Lock()
+	if err != nil {
+		s.stats.Errors++
+	} else {
+		s.stats.Compared++
+		if !diff.CountryMismatch {
+			s.stats.CountryMatches++
+		}
+		if !diff.RegionMismatch {
+			s.stats.RegionMatches++
+		}
+		if !diff.CityMismatch {
+			s.stats.CityMatches++
+		}
+		if diff.Distance >= 0 {
+			s.stats.Located++
+			s.stats.TotalDistance += diff.Distance
+			if diff.Distance > s.stats.MaxDistance {
+				s.stats.MaxDistance = diff.Distance
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	if s.OnDiff != nil {
+		s.OnDiff(diff)
+	}
+}
+
+// Diff returns the field level difference between the primary and the shadow
+// Info, the names are compared case insensitively and the regions by their
+// codes, if both have them
+func Diff(primary, shadow *Info) ShadowDiff {
+	diff := ShadowDiff{Primary: primary, Shadow: shadow, Distance: -1}
+	if primary == nil || shadow == nil {
+		return diff
+	}
+	diff.CountryMismatch = !strings.EqualFold(primary.CountryCode, shadow.CountryCode)
+	if primary.RegionCode != "" && shadow.RegionCode != "" {
+		diff.RegionMismatch = !strings.EqualFold(primary.RegionCode, shadow.RegionCode)
+	} else {
+		diff.RegionMismatch = !strings.EqualFold(primary.RegionName, shadow.RegionName)
+	}
+	diff.CityMismatch = !strings.EqualFold(primary.City, shadow.City)
+	if located(primary) && located(shadow) {
+		diff.Distance = distance(primary.Latitude, primary.Longitude, shadow.Latitude, shadow.Longitude)
+	}
+	return diff
+}
+
+// located reports whether the Info has the coordinates
+func located(info *Info) bool {
+	return info.Latitude != 0 || info.Longitude != 0
+}
+
+// distance returns the great circle distance between the coordinates in
+// kilometers
+func distance(lat1, long1, lat2, long2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLong := (long2 - long1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLong/2)*math.Sin(dLong/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP_test
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/Shivam010/go-freeGeoIP"
+)
+
+func TestShadowProvider(t *testing.T) {
+	moved := response()
+	moved.City, moved.RegionCode, moved.Latitude, moved.Longitude = "Bengaluru", "KA", 12.9716, 77.5946
+	dns := &freeGeoIP.Info{IP: freeGeoIP.ParseIP(dnsIP), CountryCode: "US", RegionName: "California", City: "Mountain View"}
+
+	primary := &tableProvider{table: map[string]*freeGeoIP.Info{responseIP: response(), dnsIP: dns}}
+	shadow := &tableProvider{table: map[string]*freeGeoIP.Info{responseIP: moved, dnsIP: dns}}
+
+	var (
+		mu    sync.Mutex
+		diffs []freeGeoIP.ShadowDiff
+	)
+	sp := &freeGeoIP.ShadowProvider{Primary: primary, Shadow: shadow, OnDiff: func(diff freeGeoIP.ShadowDiff) {
+		mu.Lock()
+		diffs = append(diffs, diff)
+		mu.Unlock()
+	}}
+	cli := &freeGeoIP.Client{Provider: sp}
+	ctx := context.Background()
+
+	// primary always answers
+	res := cli.GetGeoInfoFromString(ctx, responseIP)
+	if res.Error != nil || compare(t, res.Info, response()) {
+		t.Fatalf("GetGeoInfoFromString() got = %+v", res)
+	}
+	sp.Wait()
+	if res = cli.GetGeoInfoFromString(ctx, dnsIP); res.Error != nil || res.Info.City != "Mountain View" {
+		t.Fatalf("GetGeoInfoFromString() got = %+v", res)
+	}
+	sp.Wait()
+
+	// not shadowed, as the primary has no answer
+	if res = cli.GetGeoInfoFromString(ctx, broadcastIP); res.Error != freeGeoIP.ErrNoResponse {
+		t.Fatalf("GetGeoInfoFromString() error = %v, want %v", res.Error, freeGeoIP.ErrNoResponse)
+	}
+	// shadow fails
+	shadow.mu.Lock()
+	delete(shadow.table, dnsIP)
+	shadow.mu.Unlock()
+	_ = cli.GetGeoInfoFromString(ctx, dnsIP)
+	sp.Wait()
+
+	stats := sp.Stats()
+	if stats.Sampled != 3 || stats.Compared != 2 || stats.Errors != 1 || stats.Dropped != 0 {
+		t.Fatalf("Stats() got = %+v", stats)
+	}
+	if stats.CountryAgreement() != 1 || stats.RegionAgreement() != 1 || stats.CityAgreement() != 0.5 {
+		t.Fatalf("Stats() agreements got = %v, %v, %v", stats.CountryAgreement(), stats.RegionAgreement(),
+			stats.CityAgreement())
+	}
+	// Belgaum to Bengaluru is ~460km, and the dns ip has no coordinates
+	if stats.Located != 1 || math.Abs(stats.MeanDistance()-460) > 10 || stats.MaxDistance != stats.TotalDistance {
+		t.Fatalf("Stats() distances got = %+v, mean = %v", stats, stats.MeanDistance())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(diffs) != 3 || !diffs[0].CityMismatch || diffs[0].CountryMismatch || diffs[1].CityMismatch ||
+		diffs[1].Distance >= 0 || diffs[2].Error != freeGeoIP.ErrNoResponse {
+		t.Fatalf("OnDiff() got = %+v", diffs)
+	}
+}
+
+func TestShadowProviderSampling(t *testing.T) {
+	primary := &tableProvider{table: map[string]*freeGeoIP.Info{responseIP: response()}}
+	shadow := &tableProvider{table: map[string]*freeGeoIP.Info{responseIP: response()}}
+	sp := &freeGeoIP.ShadowProvider{Primary: primary, Shadow: shadow, SampleRate: 0.25}
+	for i := 0; i < 1000; i++ {
+		if _, _, err := sp.Lookup(context.Background(), freeGeoIP.ParseIP(responseIP)); err != nil {
+			t.Fatalf("Lookup() error = %v, want no error", err)
+		}
+	}
+	sp.Wait()
+	stats := sp.Stats()
+	if stats.Sampled < 150 || stats.Sampled > 350 || stats.Compared != stats.Sampled-stats.Dropped {
+		t.Fatalf("Stats() got = %+v, want ~250 sampled", stats)
+	}
+	if stats.CityAgreement() != 1 || stats.MaxDistance != 0 {
+		t.Fatalf("Stats() got = %+v", stats)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := &freeGeoIP.Info{CountryCode: "us", RegionName: "New York", City: "New York", Latitude: 40.7128, Longitude: -74.006}
+	b := &freeGeoIP.Info{CountryCode: "US", RegionName: "new york", City: "Brooklyn", Latitude: 51.5074, Longitude: -0.1278}
+	diff := freeGeoIP.Diff(a, b)
+	if diff.CountryMismatch || diff.RegionMismatch || !diff.CityMismatch {
+		t.Fatalf("Diff() got = %+v", diff)
+	}
+	// New York to London is ~5570km
+	if math.Abs(diff.Distance-5570) > 10 {
+		t.Fatalf("Diff() distance got = %v, want ~5570", diff.Distance)
+	}
+	if diff = freeGeoIP.Diff(a, nil); diff.Distance >= 0 {
+		t.Fatalf("Diff() got = %+v", diff)
+	}
+}
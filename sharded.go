@@ -0,0 +1,269 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP/internal/cacheutil"
+)
+
+const (
+	// _CacheShards is the number of the shards of the shardedCache, a power
+	// of two
+	_CacheShards = 64
+	// _PurgeEvery is the number of the writes to a shard, after which its
+	// expired entries are purged
+	_PurgeEvery = 1024
+)
+
+// shardedCache is the ICache, and IHostCache, implementation for the high
+// QPS services. The entries are spread across the shards, each with its own
+// lock, and are keyed by the 16 byte form of the ips, so that a cache hit
+// neither contends for a single lock nor allocates.
+type shardedCache struct {
+	shards [_CacheShards]cacheShard
+	expiry cacheutil.Expiry
+}
+
+// cacheShard is a shard of the shardedCache
+type cacheShard struct {
+	mu sync.RWMutex
+	// ips are the entries of the valid ips, and strs are the entries of the
+	// hostnames and the empty ip
+	ips    map[[net.IPv6len]byte]cacheEntry
+	strs   map[string]cacheEntry
+	writes int
+	// padding to keep the shards on separate cache lines
+	_ [64]byte
+}
+
+// cacheEntry is an entry of the shardedCache, expires is in unix nanoseconds
+// and zero if it never expires
+type cacheEntry struct {
+	info    *Info
+	expires int64
+}
+
+func (e cacheEntry) expired(now int64) bool {
+	return e.expires != 0 && now >= e.expires
+}
+
+// NewShardedCache is the constructor that returns the sharded ICache
+// implementation, see NewCache for the expiry and the expFn. It is the
+// implementation of the DefaultCache and the NonExpiryCache.
+func NewShardedCache(expiry time.Duration, expFn CacheExpiryFunction) ICache {
+	if expiry == SkipCache && expFn == nil {
+		return NoopCache{}
+	}
+	return newShardedCache(expiry, expFn)
+}
+
+// newShardedCache returns the shardedCache, the negative expiry is the
+// NoCacheExpiration
+func newShardedCache(expiry time.Duration, expFn CacheExpiryFunction) *shardedCache {
+	if expiry <= 0 {
+		expiry = NoCacheExpiration
+	}
+	c := &shardedCache{expiry: expiryOf(expiry, expFn)}
+	for i := range c.shards {
+		c.shards[i].ips = map[[net.IPv6len]byte]cacheEntry{}
+		c.shards[i].strs = map[string]cacheEntry{}
+	}
+	return c
+}
+
+// ipShard returns the shard of the ip key
+func (c *shardedCache) ipShard(key *[net.IPv6len]byte) *cacheShard {
+	h := binary.LittleEndian.Uint64(key[:8]) ^ binary.LittleEndian.Uint64(key[8:])
+	return &c.shards[(h*0x9e3779b97f4a7c15)>>58]
+}
+
+// strShard returns the shard of the string key
+func (c *shardedCache) strShard(key string) *cacheShard {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return &c.shards[h%_CacheShards]
+}
+
+// Set will use the provided expiry duration and save info in cache
+func (c *shardedCache) Set(ctx context.Context, info *Info) {
+	if info == nil {
+		return
+	}
+	entry, ok := c.entry(ctx, info)
+	if !ok {
+		return
+	}
+	if key, ok := cacheutil.IPKey(net.IP(info.IP)); ok {
+		s := c.ipShard(&key)
+		s.mu.Lock()
+		s.ips[key] = entry
+		s.wrote()
+		s.mu.Unlock()
+		return
+	}
+	c.setStr(info.IP.String(), entry)
+}
+
+// Get will retrieve the saved/cached ip info and if not found then a cache
+// missed error, `ErrCacheMissed` will be returned
+// The error will also be returned when explicit cache miss is requested
+func (c *shardedCache) Get(ctx context.Context, ip IP) (*Info, error) {
+	if c.expiry.Skip(ctx, net.IP(ip)) {
+		return nil, ErrCacheMissed
+	}
+	key, ok := cacheutil.IPKey(net.IP(ip))
+	if !ok {
+		return c.getStr(ip.String())
+	}
+	s := c.ipShard(&key)
+	s.mu.RLock()
+	entry, ok := s.ips[key]
+	s.mu.RUnlock()
+	if !ok || entry.expired(time.Now().UnixNano()) {
+		return nil, ErrCacheMissed
+	}
+	return entry.info, nil
+}
+
+// SetHost will use the expiry duration of the info's ip and save info in
+// cache under the hostname
+func (c *shardedCache) SetHost(ctx context.Context, host string, info *Info) {
+	if info == nil {
+		return
+	}
+	if entry, ok := c.entry(ctx, info); ok {
+		c.setStr(cacheutil.HostKey(host), entry)
+	}
+}
+
+// GetHost will retrieve the saved/cached hostname info and if not found then
+// a cache missed error, `ErrCacheMissed` will be returned
+// The entry of the hostname is found first, its ip is then checked with the
+// CacheExpiryFunction, so an ip denied after it was cached is a miss too
+func (c *shardedCache) GetHost(ctx context.Context, host string) (*Info, error) {
+	info, err := c.getStr(cacheutil.HostKey(host))
+	if err != nil || c.expiry.Skip(ctx, net.IP(info.IP)) {
+		return nil, ErrCacheMissed
+	}
+	return info, nil
+}
+
+// entry returns the cache entry of the info, false if it must not be cached
+func (c *shardedCache) entry(ctx context.Context, info *Info) (cacheEntry, bool) {
+	expires, ok := c.expiry.At(ctx, net.IP(info.IP), time.Now())
+	if !ok {
+		return cacheEntry{}, false
+	}
+	entry := cacheEntry{info: info}
+	if !expires.IsZero() {
+		entry.expires = expires.UnixNano()
+	}
+	return entry, true
+}
+
+func (c *shardedCache) setStr(key string, entry cacheEntry) {
+	s := c.strShard(key)
+	s.mu.Lock()
+	s.strs[key] = entry
+	s.wrote()
+	s.mu.Unlock()
+}
+
+func (c *shardedCache) getStr(key string) (*Info, error) {
+	s := c.strShard(key)
+	s.mu.RLock()
+	entry, ok := s.strs[key]
+	s.mu.RUnlock()
+	if !ok || entry.expired(time.Now().UnixNano()) {
+		return nil, ErrCacheMissed
+	}
+	return entry.info, nil
+}
+
+// wrote counts a write to the shard, and purges its expired entries every
+// _PurgeEvery writes, the lock must be held
+func (s *cacheShard) wrote() {
+	if s.writes++; s.writes < _PurgeEvery {
+		return
+	}
+	s.writes = 0
+	now := time.Now().UnixNano()
+	for key, entry := range s.ips {
+		if entry.expired(now) {
+			delete(s.ips, key)
+		}
+	}
+	for key, entry := range s.strs {
+		if entry.expired(now) {
+			delete(s.strs, key)
+		}
+	}
+}
+
+// each calls fn with every entry of the cache, not expired at now, with the
+// string form of its key. The entries are copied out of the shards first, so
+// that fn does not block the writers.
+func (c *shardedCache) each(now int64, fn func(key string, entry cacheEntry) error) error {
+	type keyed struct {
+		key   string
+		entry cacheEntry
+	}
+	var entries []keyed
+	for i := range c.shards {
+		s := &c.shards[i]
+		entries = entries[:0]
+		s.mu.RLock()
+		for key, entry := range s.ips {
+			if !entry.expired(now) {
+				entries = append(entries, keyed{net.IP(key[:]).String(), entry})
+			}
+		}
+		for key, entry := range s.strs {
+			if !entry.expired(now) {
+				entries = append(entries, keyed{key, entry})
+			}
+		}
+		s.mu.RUnlock()
+		for _, e := range entries {
+			if err := fn(e.key, e.entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// restore saves the entry under the string form of its key, as returned by
+// each
+func (c *shardedCache) restore(key string, entry cacheEntry) {
+	if ip := ParseIP(key); len(ip) != 0 {
+		k, _ := cacheutil.IPKey(net.IP(ip))
+		s := c.ipShard(&k)
+		s.mu.Lock()
+		s.ips[k] = entry
+		s.mu.Unlock()
+		return
+	}
+	c.setStr(key, entry)
+}
@@ -0,0 +1,155 @@
+// Copyright 2020 Shivam Rathore
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package freeGeoIP_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Shivam010/go-freeGeoIP"
+)
+
+func TestShardedCache(t *testing.T) {
+	cache := freeGeoIP.NewShardedCache(10*time.Millisecond, func(ctx context.Context, ip freeGeoIP.IP) time.Duration {
+		switch ip.String() {
+		case broadcastIP:
+			return freeGeoIP.SkipCache
+		case dnsIP:
+			return freeGeoIP.NoCacheExpiration
+		}
+		return 0 // the expiry
+	})
+	ctx := context.Background()
+	cache.Set(ctx, nil)
+	cache.Set(ctx, response())
+	cache.Set(ctx, broadcastResponse())
+	cache.Set(ctx, &freeGeoIP.Info{IP: freeGeoIP.IP(net.ParseIP(dnsIP).To4()), CountryCode: "US"})
+	cache.Set(ctx, &freeGeoIP.Info{CountryCode: "IN"}) // the ip of the caller
+	hc := cache.(freeGeoIP.IHostCache)
+	hc.SetHost(ctx, "example.com", response())
+
+	if got, err := cache.Get(ctx, freeGeoIP.ParseIP(responseIP)); err != nil || compare(t, got, response()) {
+		t.Fatalf("cache.Get() error = %v, want no error", err)
+	}
+	// the 4 and the 16 byte forms of an ipv4 are the same
+	if got, err := cache.Get(ctx, freeGeoIP.ParseIP(dnsIP)); err != nil || got.CountryCode != "US" {
+		t.Fatalf("cache.Get() got = %v, %v", got, err)
+	}
+	if got, err := cache.Get(ctx, nil); err != nil || got.CountryCode != "IN" {
+		t.Fatalf("cache.Get() got = %v, %v", got, err)
+	}
+	if _, err := hc.GetHost(ctx, "example.com"); err != nil {
+		t.Fatalf("cache.GetHost() error = %v, want no error", err)
+	}
+	if _, err := cache.Get(ctx, freeGeoIP.ParseIP(broadcastIP)); err != freeGeoIP.ErrCacheMissed {
+		t.Fatalf("cache.Get() error = %v, want %v", err, freeGeoIP.ErrCacheMissed)
+	}
+
+	time.Sleep(15 * time.Millisecond)
+	if _, err := cache.Get(ctx, freeGeoIP.ParseIP(responseIP)); err != freeGeoIP.ErrCacheMissed {
+		t.Fatalf("cache.Get() error = %v, want %v", err, freeGeoIP.ErrCacheMissed)
+	}
+	if _, err := hc.GetHost(ctx, "example.com"); err != freeGeoIP.ErrCacheMissed {
+		t.Fatalf("cache.GetHost() error = %v, want %v", err, freeGeoIP.ErrCacheMissed)
+	}
+	if _, err := cache.Get(ctx, freeGeoIP.IP(net.ParseIP(dnsIP).To4())); err != nil {
+		t.Fatalf("cache.Get() error = %v, want no error", err)
+	}
+
+	if _, ok := freeGeoIP.NewShardedCache(freeGeoIP.SkipCache, nil).(freeGeoIP.NoopCache); !ok {
+		t.Fatalf("NewShardedCache() with SkipCache must be the NoopCache")
+	}
+}
+
+func TestShardedCacheNoAllocs(t *testing.T) {
+	cache := freeGeoIP.DefaultCache()
+	ctx := context.Background()
+	cache.Set(ctx, response())
+	ip := freeGeoIP.ParseIP(responseIP)
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := cache.Get(ctx, ip); err != nil {
+			t.Fatalf("cache.Get() error = %v, want no error", err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("cache.Get() allocs got = %v, want %v", allocs, 0)
+	}
+}
+
+// benchmarkCaches are the ICache implementations compared in the benchmarks
+var benchmarkCaches = []struct {
+	name  string
+	cache func() freeGeoIP.ICache
+}{
+	{"go-cache", func() freeGeoIP.ICache { return freeGeoIP.NewCache(freeGeoIP.DefaultCacheExpiry, nil) }},
+	{"sharded", func() freeGeoIP.ICache { return freeGeoIP.NewShardedCache(freeGeoIP.DefaultCacheExpiry, nil) }},
+}
+
+// benchmarkIPs returns n distinct ipv4 addresses
+func benchmarkIPs(n int) []freeGeoIP.IP {
+	ips := make([]freeGeoIP.IP, n)
+	for i := range ips {
+		ips[i] = freeGeoIP.IP(net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)))
+	}
+	return ips
+}
+
+func BenchmarkCacheGet(b *testing.B) {
+	ips := benchmarkIPs(1 << 12)
+	for _, bc := range benchmarkCaches {
+		b.Run(bc.name, func(b *testing.B) {
+			cache, ctx := bc.cache(), context.Background()
+			for _, ip := range ips {
+				cache.Set(ctx, &freeGeoIP.Info{IP: ip})
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					if _, err := cache.Get(ctx, ips[i&(len(ips)-1)]); err != nil {
+						b.Fatal(err)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkCacheMixed(b *testing.B) {
+	ips := benchmarkIPs(1 << 12)
+	for _, bc := range benchmarkCaches {
+		b.Run(bc.name, func(b *testing.B) {
+			cache, ctx := bc.cache(), context.Background()
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					ip := ips[i&(len(ips)-1)]
+					if i%10 == 0 {
+						cache.Set(ctx, &freeGeoIP.Info{IP: ip})
+					} else {
+						_, _ = cache.Get(ctx, ip)
+					}
+					i++
+				}
+			})
+		})
+	}
+}